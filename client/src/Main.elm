@@ -10,6 +10,7 @@ import Html
 import Html.Attributes as HtmlA
 import Html.Events as HtmlE
 import Http
+import Json.Decode
 import List.Extra
 import Menu
 import MultiPlay
@@ -62,7 +63,9 @@ type Page
 type alias Params =
     { key : Maybe String
     , room : Maybe String
+    , invite : Maybe String
     , name : Maybe String
+    , team : Maybe String
     , game : Maybe Game.GameDef
     , scored : Bool
     }
@@ -74,14 +77,6 @@ init loc =
         params =
             parseParams loc
 
-        genRoom =
-            case params.room of
-                Nothing ->
-                    [ Random.generate SetRoom (randHex 6) ]
-
-                Just _ ->
-                    []
-
         style =
             Style.square
     in
@@ -91,7 +86,7 @@ init loc =
       , page = newMenu style params Nothing
       , size = { w = 0, h = 0 }
       }
-    , Cmd.batch <| getSize () :: genRoom
+    , getSize ()
     )
 
 
@@ -100,6 +95,7 @@ newMenu style params score =
     Menu
         { score = score
         , scoreDetails = False
+        , error = Nothing
         , name = params.name
         , game = params.game
         , style = style
@@ -144,7 +140,13 @@ type Msg
     | APIWinResult (Result Http.Error String)
     | APINewResult Msg (Result Http.Error String)
     | Resize Size
-    | SetRoom String
+    | RoomCreated Game.GameDef String (Result Http.Error CreatedRoom)
+
+
+type alias CreatedRoom =
+    { room : String
+    , invite : String
+    }
 
 
 type APICall
@@ -175,19 +177,49 @@ update msg model =
             ( { model | page = Menu (Menu.update mmsg menu) }, Cmd.none )
 
         ( Go def name, _ ) ->
-            if def.multi then
+            if def.multi && model.params.room == Nothing then
+                ( model, createRoom model.location def name )
+
+            else if def.multi then
                 let
                     room =
-                        Maybe.withDefault "veryunlikely" model.params.room
+                        Maybe.withDefault "" model.params.room
 
                     game =
                         Game.gameId def
 
+                    key =
+                        case model.params.key of
+                            Just k ->
+                                "&key=" ++ k
+
+                            Nothing ->
+                                ""
+
+                    team =
+                        case model.params.team of
+                            Just t ->
+                                "&team=" ++ t
+
+                            Nothing ->
+                                ""
+
+                    invite =
+                        case model.params.invite of
+                            Just i ->
+                                "&invite=" ++ Http.encodeUri i
+
+                            Nothing ->
+                                ""
+
                     ws =
-                        joinUrl model.location ++ "?room=" ++ room ++ "&game=" ++ game ++ "&name=" ++ name
+                        joinUrl model.location ++ "?room=" ++ room ++ "&game=" ++ game ++ "&name=" ++ name ++ key ++ team ++ invite ++ protocol
+
+                    protocol =
+                        "&protocol=1&caps=chat,errors,notices,pings,tournaments"
 
                     share =
-                        shareUrl model.location ++ "?room=" ++ room ++ "&game=" ++ game
+                        shareUrl model.location ++ "?room=" ++ room ++ "&game=" ++ game ++ invite
 
                     m =
                         MultiPlay.init (Game.empty def) ws share
@@ -241,12 +273,15 @@ update msg model =
             in
             ( { model | page = MultiPlay newpmodel }, cmd )
 
-        ( SetRoom room, _ ) ->
+        ( RoomCreated def name (Ok created), _ ) ->
             let
                 oldParams =
                     model.params
             in
-            ( { model | params = { oldParams | room = Just room } }, Cmd.none )
+            update (Go def name) { model | params = { oldParams | room = Just created.room, invite = Just created.invite } }
+
+        ( RoomCreated _ _ (Err err), Menu menu ) ->
+            ( { model | page = Menu { menu | error = Just (roomError err) } }, Cmd.none )
 
         _ ->
             ( model, Cmd.none )
@@ -259,17 +294,21 @@ parseParams loc =
             UrlParser.top
                 <?> UrlParser.stringParam "key"
                 <?> UrlParser.stringParam "room"
+                <?> UrlParser.stringParam "invite"
                 <?> UrlParser.stringParam "name"
+                <?> UrlParser.stringParam "team"
                 <?> UrlParser.stringParam "game"
                 <?> UrlParser.stringParam "scored"
 
         parseParams parser location =
             UrlParser.parseHash parser { location | hash = "" }
 
-        f k r n g sc =
+        f k r i n t g sc =
             { key = k
             , room = r
+            , invite = i
             , name = n
+            , team = t
             , game =
                 case g of
                     Just gg ->
@@ -319,6 +358,11 @@ newUrl loc =
     loc.protocol ++ "//" ++ loc.host ++ loc.pathname </> "api/new"
 
 
+roomsUrl : Navigation.Location -> String
+roomsUrl loc =
+    loc.protocol ++ "//" ++ loc.host ++ loc.pathname </> "api/rooms"
+
+
 joinUrl : Navigation.Location -> String
 joinUrl loc =
     let
@@ -343,6 +387,36 @@ sendScore location key score =
                 ++ toString score
 
 
+roomError : Http.Error -> String
+roomError err =
+    case err of
+        Http.BadStatus resp ->
+            "Could not create a room: " ++ String.trim resp.body
+
+        Http.Timeout ->
+            "Could not create a room: the server took too long"
+
+        Http.NetworkError ->
+            "Could not create a room: the server can't be reached"
+
+        _ ->
+            "Could not create a room"
+
+
+createRoom : Navigation.Location -> Game.GameDef -> String -> Cmd Msg
+createRoom location def name =
+    let
+        body =
+            Http.stringBody "application/x-www-form-urlencoded" ("game=" ++ Game.gameId def)
+
+        decoder =
+            Json.Decode.map2 CreatedRoom
+                (Json.Decode.field "room" Json.Decode.string)
+                (Json.Decode.field "invite" Json.Decode.string)
+    in
+    Http.send (RoomCreated def name) <| Http.post (roomsUrl location) body decoder
+
+
 type alias MatchStats =
     { time : Time.Time
     , event : Play.Event -- EMatch or EEnd
@@ -559,38 +633,3 @@ after time msg =
     in
     Task.perform msg task
 
-
-
---| Generate n random bytes, hex encoded
-
-
-randHex : Int -> Random.Generator String
-randHex n =
-    let
-        hex i =
-            case i of
-                10 ->
-                    "a"
-
-                11 ->
-                    "b"
-
-                12 ->
-                    "c"
-
-                13 ->
-                    "d"
-
-                14 ->
-                    "e"
-
-                15 ->
-                    "f"
-
-                _ ->
-                    toString i
-
-        one =
-            Random.int 0 15 |> Random.map hex
-    in
-    Random.list (2 * n) one |> Random.map String.concat
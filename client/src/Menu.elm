@@ -22,6 +22,7 @@ type alias Score =
 type alias Model =
     { score : Maybe Score
     , scoreDetails : Bool
+    , error : Maybe String
     , game : Maybe Game.GameDef
     , name : Maybe String
     , style : Style.Style
@@ -72,6 +73,16 @@ view wrap go model =
                         (head :: details)
                         :: h
 
+        addError h =
+            case model.error of
+                Nothing ->
+                    h
+
+                Just e ->
+                    Html.div [ HtmlA.class "msg", HtmlA.style [ ( "background", fst model.style.colors.symbols ) ] ]
+                        [ Html.span [] [ Html.text e ] ]
+                        :: h
+
         prompt =
             case model.game of
                 Nothing ->
@@ -130,23 +141,24 @@ view wrap go model =
         buttonStyle = HtmlA.style [ ( "background", snd model.style.colors.symbols ) ]
     in
     Html.div [ HtmlA.id "menu" ] <|
-        addScore <|
-            [ Html.div
-                [ HtmlA.class "msg", HtmlA.style [ ( "background", trd model.style.colors.symbols ) ] ]
-                [ Html.span [] [ Html.text prompt ] ]
-            , case model.game of
-                Nothing ->
-                    Html.div [ HtmlA.class "buttons" ] <|
-                        [ Html.button [ buttonStyle, HtmlE.onClick <| gogo def ] [ Html.text "Classic" ]
-                        , Html.button [ buttonStyle, HtmlE.onClick <| gogo { def | short = True } ] [ Html.text "Classic (short)" ]
-                        , Html.button [ buttonStyle, HtmlE.onClick <| gogo { def | multi = True }, HtmlA.disabled (model.name == Nothing) ] [ Html.text "Classic (multi)" ]
-                        , Html.button [ buttonStyle, HtmlE.onClick <| gogo { def | type_ = Game.Quadruples } ] [ Html.text "Super" ]
-                        , Html.button [ buttonStyle, HtmlE.onClick <| gogo { def | type_ = Game.Quadruples, short = True } ] [ Html.text "Super (short)" ]
-                        , Html.button [ buttonStyle, HtmlE.onClick <| gogo { def | type_ = Game.Quadruples, multi = True }, HtmlA.disabled (model.name == Nothing) ] [ Html.text "Super (multi)" ]
-                        ]
-
-                Just d ->
-                    Html.div [ HtmlA.class "button" ] <|
-                        [ Html.button [ HtmlE.onClick <| gogo d ] [ Html.text "Play!" ] ]
-            ]
-                ++ maybeName
+        addError <|
+            addScore <|
+                [ Html.div
+                    [ HtmlA.class "msg", HtmlA.style [ ( "background", trd model.style.colors.symbols ) ] ]
+                    [ Html.span [] [ Html.text prompt ] ]
+                , case model.game of
+                    Nothing ->
+                        Html.div [ HtmlA.class "buttons" ] <|
+                            [ Html.button [ buttonStyle, HtmlE.onClick <| gogo def ] [ Html.text "Classic" ]
+                            , Html.button [ buttonStyle, HtmlE.onClick <| gogo { def | short = True } ] [ Html.text "Classic (short)" ]
+                            , Html.button [ buttonStyle, HtmlE.onClick <| gogo { def | multi = True }, HtmlA.disabled (model.name == Nothing) ] [ Html.text "Classic (multi)" ]
+                            , Html.button [ buttonStyle, HtmlE.onClick <| gogo { def | type_ = Game.Quadruples } ] [ Html.text "Super" ]
+                            , Html.button [ buttonStyle, HtmlE.onClick <| gogo { def | type_ = Game.Quadruples, short = True } ] [ Html.text "Super (short)" ]
+                            , Html.button [ buttonStyle, HtmlE.onClick <| gogo { def | type_ = Game.Quadruples, multi = True }, HtmlA.disabled (model.name == Nothing) ] [ Html.text "Super (multi)" ]
+                            ]
+
+                    Just d ->
+                        Html.div [ HtmlA.class "button" ] <|
+                            [ Html.button [ HtmlE.onClick <| gogo d ] [ Html.text "Play!" ] ]
+                ]
+                    ++ maybeName
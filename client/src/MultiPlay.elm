@@ -30,6 +30,10 @@ type alias Model =
     , scores : Dict.Dict String Status
     , selected : List Game.Pos
     , log : List String
+    , standings : Maybe StandingsRecord
+    , teams : Dict.Dict String Int
+    , chat : List ChatLine
+    , chatInput : String
     }
 
 
@@ -41,6 +45,10 @@ init game wsURL joinLink =
     , scores = Dict.empty
     , selected = []
     , log = []
+    , standings = Nothing
+    , teams = Dict.empty
+    , chat = []
+    , chatInput = ""
     }
 
 
@@ -48,6 +56,13 @@ type UserMsg
     = Choose Game.Pos
     | UserDeal
     | UserStart
+    | UserStartTournament Int Bool
+    | UserTeam String
+    | UserChatInput String
+    | UserChat
+    | UserReact String
+    | UserAddBot String
+    | UserRemoveBot
 
 
 type Msg
@@ -72,8 +87,11 @@ viewWait style model =
                 ([ Html.thead []
                     [ Html.tr []
                         [ Html.th [] [ Html.text "Name" ]
+                        , Html.th [] [ Html.text "Team" ]
                         , Html.th [] [ Html.text "Score" ]
+                        , Html.th [] [ Html.text "Rating" ]
                         , Html.th [] [ Html.text "Online" ]
+                        , Html.th [] [ Html.text "Ping" ]
                         ]
                     ]
                  ]
@@ -81,7 +99,16 @@ viewWait style model =
                         (\( n, s ) ->
                             Html.tr []
                                 [ Html.td [] [ Html.text n ]
+                                , Html.td [] [ Html.text s.team ]
                                 , Html.td [] [ Html.text <| toString s.score ]
+                                , Html.td []
+                                    [ Html.text <|
+                                        if s.rating == 0 then
+                                            ""
+
+                                        else
+                                            toString s.rating
+                                    ]
                                 , Html.td []
                                     [ Html.text <|
                                         if s.present then
@@ -90,29 +117,164 @@ viewWait style model =
                                         else
                                             "no"
                                     ]
+                                , Html.td []
+                                    [ Html.text <|
+                                        if s.ping == 0 || not s.present then
+                                            ""
+
+                                        else
+                                            toString s.ping ++ " ms"
+                                    ]
                                 ]
                         )
                         scores
                 )
 
+        listTeams teams =
+            Html.table []
+                ([ Html.thead []
+                    [ Html.tr []
+                        [ Html.th [] [ Html.text "Team" ]
+                        , Html.th [] [ Html.text "Score" ]
+                        ]
+                    ]
+                 ]
+                    ++ List.map
+                        (\( t, s ) ->
+                            Html.tr []
+                                [ Html.td [] [ Html.text t ]
+                                , Html.td [] [ Html.text <| toString s ]
+                                ]
+                        )
+                        (teamTable teams)
+                )
+
+        teamChoices =
+            if Dict.isEmpty model.teams then
+                [ "Team 1", "Team 2" ]
+
+            else
+                Dict.keys model.teams
+
+        viewTeams =
+            [ Html.div [ HtmlA.class "button" ]
+                (List.map
+                    (\t -> Html.button [ HtmlE.onClick (User (UserTeam t)) ] [ Html.text <| "Join " ++ t ])
+                    teamChoices
+                    ++ [ Html.button [ HtmlE.onClick (User (UserTeam "")) ] [ Html.text "Play alone" ] ]
+                )
+            ]
+                ++ (if Dict.isEmpty model.teams then
+                        []
+
+                    else
+                        [ Html.div [ HtmlA.class "msg", HtmlA.style [ ( "background", bg1 ) ] ]
+                            [ listTeams model.teams ]
+                        ]
+                   )
+
+        viewChat =
+            [ Html.div [ HtmlA.class "msg", HtmlA.class "log", HtmlA.style [ ( "background", bg2 ) ] ]
+                (List.map
+                    (\l -> Html.div [ HtmlA.class "event" ] [ Html.b [] [ Html.text <| l.name ++ ": " ], Html.text l.text ])
+                    model.chat
+                )
+            , Html.form [ HtmlA.class "button", HtmlE.onSubmit (User UserChat) ]
+                [ Html.input
+                    [ HtmlA.value model.chatInput
+                    , HtmlA.maxlength 200
+                    , HtmlA.placeholder "Say something"
+                    , HtmlE.onInput (User << UserChatInput)
+                    ]
+                    []
+                , Html.button [ HtmlA.type_ "submit" ] [ Html.text "Send" ]
+                ]
+            , Html.div [ HtmlA.class "button" ]
+                (List.map
+                    (\r -> Html.button [ HtmlE.onClick (User (UserReact r)) ] [ Html.text r ])
+                    reactions
+                )
+            ]
+
+        listStandings st =
+            Html.table []
+                ([ Html.thead []
+                    [ Html.tr []
+                        [ Html.th [] [ Html.text "" ]
+                        , Html.th [] [ Html.text "Name" ]
+                        , Html.th [] [ Html.text "Total" ]
+                        , Html.th [] [ Html.text "Won" ]
+                        ]
+                    ]
+                 ]
+                    ++ List.map
+                        (\s ->
+                            Html.tr []
+                                [ Html.td [] [ Html.text <| toString s.place ++ "." ]
+                                , Html.td [] [ Html.text s.name ]
+                                , Html.td [] [ Html.text <| toString s.score ]
+                                , Html.td [] [ Html.text <| toString s.wins ]
+                                ]
+                        )
+                        (if st.final then
+                            List.filter (\s -> s.place <= 3) st.standings
+
+                         else
+                            st.standings
+                        )
+                )
+
+        standingsTitle st =
+            if st.final then
+                "Tournament podium"
+
+            else
+                "Standings after round " ++ toString st.round ++ " of " ++ toString st.rounds
+
+        viewStandings =
+            case model.standings of
+                Nothing ->
+                    []
+
+                Just st ->
+                    [ Html.div [ HtmlA.class "msg", HtmlA.style [ ( "background", bg1 ) ] ]
+                        [ Html.div [] [ Html.text <| standingsTitle st ]
+                        , listStandings st
+                        ]
+                    ]
+
         listEvents events =
             List.map (\e -> Html.div [ HtmlA.class "event" ] [ Html.text e ]) events
 
         ( _, bg1, bg2 ) =
             style.colors.symbols
     in
-    Html.div [ HtmlA.id "menu" ]
+    Html.div [ HtmlA.id "menu" ] <|
         [ Html.div [ HtmlA.class "msg", HtmlA.style [ ( "background", bg2 ) ] ]
             [ Html.div [] [ Html.text "Share this link with other players" ]
             , Html.a [ HtmlA.class "share", HtmlA.href model.joinLink ] [ Html.text model.joinLink ]
             ]
         , Html.div [ HtmlA.class "button" ]
             [ Html.button [ HtmlE.onClick (User UserStart) ] [ Html.text "Start game!" ] ]
-        , Html.div [ HtmlA.class "msg", HtmlA.style [ ( "background", bg1 ) ] ]
-            [ listScores <| scoreTable model.scores ]
-        , Html.div [ HtmlA.class "msg", HtmlA.class "log", HtmlA.style [ ( "background", bg2 ) ] ]
-            (listEvents <| model.log)
+        , Html.div [ HtmlA.class "button" ]
+            [ Html.button [ HtmlE.onClick (User (UserStartTournament 3 False)) ] [ Html.text "Start 3 round tournament" ]
+            , Html.button [ HtmlE.onClick (User (UserStartTournament 4 True)) ] [ Html.text "Start 4 round mixed tournament" ]
+            ]
+        , Html.div [ HtmlA.class "button" ]
+            [ Html.button [ HtmlE.onClick (User (UserAddBot "easy")) ] [ Html.text "Add easy bot" ]
+            , Html.button [ HtmlE.onClick (User (UserAddBot "medium")) ] [ Html.text "Add medium bot" ]
+            , Html.button [ HtmlE.onClick (User (UserAddBot "hard")) ] [ Html.text "Add hard bot" ]
+            , Html.button [ HtmlE.onClick (User UserRemoveBot) ] [ Html.text "Remove bot" ]
+            ]
         ]
+            ++ viewTeams
+            ++ viewStandings
+            ++ viewChat
+            ++ [ Html.div [ HtmlA.class "msg", HtmlA.style [ ( "background", bg1 ) ] ]
+                    [ listScores <| scoreTable model.scores ]
+               , Html.div [ HtmlA.class "msg", HtmlA.class "log", HtmlA.style [ ( "background", bg2 ) ] ]
+                    (listEvents <| model.log)
+               ]
 
 
 viewPlay : Style.Style -> Play.Size -> Model -> Html.Html Msg
@@ -183,6 +345,43 @@ update msg model =
             , sendCommand model.wsURL <| Start
             )
 
+        User (UserStartTournament rounds alternate) ->
+            ( model
+            , sendCommand model.wsURL <| StartTournament rounds alternate
+            )
+
+        User (UserTeam team) ->
+            ( model
+            , sendCommand model.wsURL <| Team team
+            )
+
+        User (UserChatInput text) ->
+            ( { model | chatInput = text }, Cmd.none )
+
+        User UserChat ->
+            if String.isEmpty (String.trim model.chatInput) then
+                ( model, Cmd.none )
+
+            else
+                ( { model | chatInput = "" }
+                , sendCommand model.wsURL <| Chat model.chatInput
+                )
+
+        User (UserReact reaction) ->
+            ( model
+            , sendCommand model.wsURL <| React reaction
+            )
+
+        User (UserAddBot level) ->
+            ( model
+            , sendCommand model.wsURL <| AddBot level
+            )
+
+        User UserRemoveBot ->
+            ( model
+            , sendCommand model.wsURL <| RemoveBot
+            )
+
         WSUpdate u ->
             case
                 Decode.decodeString updateDecoder u
@@ -195,15 +394,25 @@ update msg model =
 
 
 type Update
-    = Full FullRecord
-    | EventOnline String Bool
+    = Hello Int
+    | Full FullRecord
+    | EventOnline String Bool String
     | EventClaimed ClaimRecord
+    | EventStandings StandingsRecord
+    | EventChat ChatLine
+    | EventReact String String
+    | EventNotice String
+    | EventInvalid String String
+    | EventPing String Int
     | Change Game.Action
 
 
 type alias Status =
     { present : Bool
     , score : Int
+    , team : String
+    , rating : Int
+    , ping : Int
     }
 
 
@@ -223,6 +432,35 @@ type alias ClaimRecord =
     , type_ : ClaimType
     , result : ResultType
     , score : Int
+    , team : String
+    , teamScore : Int
+    }
+
+
+type alias ChatLine =
+    { name : String
+    , text : String
+    }
+
+
+reactions : List String
+reactions =
+    [ "👍", "👏", "😮", "😂", "😢", "🔥" ]
+
+
+type alias Standing =
+    { place : Int
+    , name : String
+    , score : Int
+    , wins : Int
+    }
+
+
+type alias StandingsRecord =
+    { round : Int
+    , rounds : Int
+    , final : Bool
+    , standings : List Standing
     }
 
 
@@ -233,6 +471,8 @@ type alias FullRecord =
     , deckSize : Int
     , cards : Dict.Dict Game.Pos Card.Card
     , players : Dict.Dict String Status
+    , teams : Dict.Dict String Int
+    , chat : List ChatLine
     }
 
 
@@ -258,26 +498,63 @@ updateDecoder =
             Decode.dict pos card
 
         status =
-            Decode.map2
+            Decode.map5
                 Status
                 (Decode.field "present" Decode.bool)
                 (Decode.field "score" Decode.int)
+                (Decode.field "team" Decode.string)
+                (Decode.field "rating" Decode.int)
+                (Decode.field "ping" Decode.int)
 
         full =
             Decode.map Full <|
-                Decode.map6
-                    FullRecord
-                    (Decode.field "cols" Decode.int)
-                    (Decode.field "rows" Decode.int)
-                    (Decode.field "matchSize" Decode.int)
-                    (Decode.field "deckSize" Decode.int)
-                    (Decode.field "cards" cards)
-                    (Decode.field "players" (Decode.dict Decode.string status))
+                Decode.map3 (\f teams chat -> f teams chat)
+                    (Decode.map6
+                        FullRecord
+                        (Decode.field "cols" Decode.int)
+                        (Decode.field "rows" Decode.int)
+                        (Decode.field "matchSize" Decode.int)
+                        (Decode.field "deckSize" Decode.int)
+                        (Decode.field "cards" cards)
+                        (Decode.field "players" (Decode.dict Decode.string status))
+                    )
+                    (Decode.field "teams" (Decode.dict Decode.string Decode.int))
+                    (Decode.field "chat" (Decode.vector chatLine))
+
+        chatLine =
+            Decode.map2 ChatLine
+                (Decode.field "name" Decode.string)
+                (Decode.field "text" Decode.string)
+
+        eventChat =
+            Decode.map EventChat chatLine
+
+        hello =
+            Decode.map Hello (Decode.field "version" Decode.int)
+
+        eventPing =
+            Decode.map2 EventPing
+                (Decode.field "name" Decode.string)
+                (Decode.field "ping" Decode.int)
+
+        eventNotice =
+            Decode.map EventNotice (Decode.field "text" Decode.string)
+
+        eventInvalid =
+            Decode.map2 EventInvalid
+                (Decode.field "command" Decode.string)
+                (Decode.field "error" Decode.string)
+
+        eventReact =
+            Decode.map2 EventReact
+                (Decode.field "name" Decode.string)
+                (Decode.field "reaction" Decode.string)
 
         eventOnline =
-            Decode.map2 EventOnline
+            Decode.map3 EventOnline
                 (Decode.field "name" Decode.string)
                 (Decode.field "present" Decode.bool)
+                (Decode.field "team" Decode.string)
 
         claimType =
             Decode.string
@@ -314,12 +591,40 @@ updateDecoder =
 
         eventClaimed =
             Decode.map EventClaimed <|
-                Decode.map4
+                Decode.map6
                     ClaimRecord
                     (Decode.field "name" Decode.string)
                     (Decode.field "type" claimType)
                     (Decode.field "result" resultType)
                     (Decode.field "score" Decode.int)
+                    (Decode.field "team" Decode.string)
+                    (Decode.field "teamScore" Decode.int)
+
+        standing =
+            Decode.map4
+                Standing
+                (Decode.field "place" Decode.int)
+                (Decode.field "name" Decode.string)
+                (Decode.field "score" Decode.int)
+                (Decode.field "wins" Decode.int)
+
+        eventStandings =
+            Decode.map EventStandings <|
+                Decode.map4
+                    StandingsRecord
+                    (Decode.field "round" Decode.int)
+                    (Decode.field "rounds" Decode.int)
+                    (Decode.succeed False)
+                    (Decode.field "standings" (Decode.vector standing))
+
+        eventPodium =
+            Decode.map EventStandings <|
+                Decode.map4
+                    StandingsRecord
+                    (Decode.succeed 0)
+                    (Decode.succeed 0)
+                    (Decode.succeed True)
+                    (Decode.field "standings" (Decode.vector standing))
 
         changeMatch =
             Decode.map (Change << Game.Match)
@@ -343,6 +648,14 @@ updateDecoder =
         [ ( "triples/full", full )
         , ( "triples/eventOnline", eventOnline )
         , ( "triples/eventClaimed", eventClaimed )
+        , ( "triples/eventStandings", eventStandings )
+        , ( "triples/eventPodium", eventPodium )
+        , ( "triples/eventChat", eventChat )
+        , ( "triples/eventReact", eventReact )
+        , ( "triples/eventNotice", eventNotice )
+        , ( "triples/eventInvalid", eventInvalid )
+        , ( "triples/hello", hello )
+        , ( "triples/eventPing", eventPing )
         , ( "triples/changeMatch", changeMatch )
         , ( "triples/changeDeal", changeDeal )
         , ( "triples/changeMove", changeMove )
@@ -362,13 +675,15 @@ applyUpdate update model =
                     , matchSize = full.matchSize
                     }
                 , scores = full.players
+                , teams = full.teams
+                , chat = full.chat
                 , selected = []
             }
 
         Change action ->
             { model | game = Game.viewApply action model.game, selected = Game.selectedApply action model.selected }
 
-        EventOnline name online ->
+        EventOnline name online team ->
             { model
                 | log =
                     (name
@@ -380,9 +695,44 @@ applyUpdate update model =
                            )
                     )
                         :: model.log
-                , scores = updateStatus name (\s -> { s | present = online }) model.scores
+                , scores = updateStatus name (\s -> { s | present = online, team = team }) model.scores
+                , teams =
+                    if team == "" then
+                        model.teams
+
+                    else
+                        Dict.update team (Just << Maybe.withDefault 0) model.teams
             }
 
+        EventChat line ->
+            { model | chat = List.drop (List.length model.chat - 19) model.chat ++ [ line ] }
+
+        Hello _ ->
+            model
+
+        EventPing name ping ->
+            { model | scores = updateStatus name (\s -> { s | ping = ping }) model.scores }
+
+        EventNotice text ->
+            { model | log = ("Notice: " ++ text) :: model.log }
+
+        EventInvalid command error ->
+            { model | log = ("Refused " ++ command ++ ": " ++ error) :: model.log }
+
+        EventReact name reaction ->
+            { model | log = (name ++ " " ++ reaction) :: model.log }
+
+        EventStandings st ->
+            let
+                msg =
+                    if st.final then
+                        "Tournament over!"
+
+                    else
+                        "Round " ++ toString st.round ++ " of " ++ toString st.rounds ++ " over"
+            in
+            { model | standings = Just st, log = msg :: model.log }
+
         EventClaimed claimed ->
             let
                 typ =
@@ -407,6 +757,12 @@ applyUpdate update model =
             { model
                 | log = (claimed.name ++ " claimed " ++ typ ++ " (" ++ res ++ ")") :: model.log
                 , scores = updateStatus claimed.name (\s -> { s | score = claimed.score }) model.scores
+                , teams =
+                    if claimed.team == "" then
+                        model.teams
+
+                    else
+                        Dict.insert claimed.team claimed.teamScore model.teams
             }
 
 
@@ -414,7 +770,7 @@ updateStatus : String -> (Status -> Status) -> Dict.Dict String Status -> Dict.D
 updateStatus name f =
     Dict.update
         name
-        (\ms -> ms |> Maybe.withDefault { score = 0, present = False } |> f |> Just)
+        (\ms -> ms |> Maybe.withDefault { score = 0, present = False, team = "", rating = 0, ping = 0 } |> f |> Just)
 
 
 scoreTable : Dict.Dict String Status -> List ( String, Status )
@@ -434,9 +790,22 @@ scoreTable statuses =
             )
 
 
+teamTable : Dict.Dict String Int -> List ( String, Int )
+teamTable teams =
+    teams
+        |> Dict.toList
+        |> List.sortBy (\( t, s ) -> ( -s, t ))
+
+
 type Command
     = Claim ClaimType (List Card.Card)
     | Start
+    | StartTournament Int Bool
+    | Team String
+    | Chat String
+    | React String
+    | AddBot String
+    | RemoveBot
 
 
 encodeCommand : Command -> Encode.Element
@@ -464,6 +833,32 @@ encodeCommand cmd =
         Start ->
             Encode.mustTagged "triples/start" (Encode.object [])
 
+        StartTournament rounds alternate ->
+            Encode.mustTagged "triples/start" <|
+                Encode.mustObject
+                    [ ( "rounds", Encode.int rounds )
+                    , ( "alternate", Encode.bool alternate )
+                    ]
+
+        Team team ->
+            Encode.mustTagged "triples/team" <|
+                Encode.mustObject [ ( "team", Encode.string team ) ]
+
+        Chat text ->
+            Encode.mustTagged "triples/chat" <|
+                Encode.mustObject [ ( "text", Encode.string text ) ]
+
+        React reaction ->
+            Encode.mustTagged "triples/react" <|
+                Encode.mustObject [ ( "reaction", Encode.string reaction ) ]
+
+        AddBot level ->
+            Encode.mustTagged "triples/addBot" <|
+                Encode.mustObject [ ( "level", Encode.string level ) ]
+
+        RemoveBot ->
+            Encode.mustTagged "triples/removeBot" (Encode.object [])
+
 
 sendCommand : String -> Command -> Cmd msg
 sendCommand wsUrl =
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/robx/triples/serve/triples"
+)
+
+const kickedNotice = "You have been removed from this room."
+
+// RoomState is what the admin API shows of a room.
+type RoomState struct {
+	Game       string               `json:"game"`
+	Room       string               `json:"room"`
+	Clients    []ClientState        `json:"clients"`
+	Bots       []string             `json:"bots"`
+	Playing    bool                 `json:"playing"`
+	DeckSize   int                  `json:"deckSize"`
+	Cards      []triples.PlacedCard `json:"cards,omitempty"`
+	Scores     map[string]int       `json:"scores,omitempty"`
+	Teams      map[string]string    `json:"teams,omitempty"`
+	Chat       []ChatLine           `json:"chat,omitempty"`
+	Tournament *EventStandings      `json:"tournament,omitempty"`
+}
+
+// ClientState is a connection to a room. Players who
+// have several connections are listed once for each.
+type ClientState struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Team  string `json:"team,omitempty"`
+	Keyed bool   `json:"keyed"` // joined with a Telegram key
+	RTT   int    `json:"rtt"`   // round-trip milliseconds, 0 if unknown
+}
+
+type kick struct {
+	name string
+	done chan<- int // how many connections were dropped
+}
+
+// inspect returns the room's state, or false
+// if the room has been closed.
+func (r *Room) inspect() (RoomState, bool) {
+	c := make(chan RoomState, 1)
+	select {
+	case r.inspects <- c:
+		return <-c, true
+	case <-r.quit:
+		return RoomState{}, false
+	}
+}
+
+// kick drops a player's connections and keeps them
+// from coming back while the room is open.
+func (r *Room) kick(name string) int {
+	c := make(chan int, 1)
+	select {
+	case r.kicks <- kick{name: name, done: c}:
+		return <-c
+	case <-r.quit:
+		return 0
+	}
+}
+
+func (r *Room) notice(text string) {
+	select {
+	case r.notices <- text:
+	case <-r.quit:
+	}
+}
+
+// all returns the open rooms by game and room name.
+func (rs *Rooms) all() map[[2]string]*Room {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	all := map[[2]string]*Room{}
+	for k, r := range rs.rooms {
+		all[k] = r
+	}
+	return all
+}
+
+// remove closes a room right away, whoever is in it.
+func (rs *Rooms) remove(game, room string) bool {
+	key := [2]string{game, room}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rm := rs.rooms[key]
+	if rm == nil {
+		return false
+	}
+	delete(rs.rooms, key)
+	rm.close()
+	return true
+}
+
+// admin adds the admin API to a router. Every request
+// needs the token as a bearer token.
+func admin(r *httprouter.Router, rooms *Rooms, token string) {
+	r.GET("/api/admin/rooms", adminAuth(token, adminRoomsHandler(rooms)))
+	r.GET("/api/admin/rooms/:game/:room", adminAuth(token, adminRoomHandler(rooms)))
+	r.DELETE("/api/admin/rooms/:game/:room", adminAuth(token, adminCloseHandler(rooms)))
+	r.POST("/api/admin/rooms/:game/:room/kick", adminAuth(token, adminKickHandler(rooms)))
+	r.POST("/api/admin/broadcast", adminAuth(token, adminBroadcastHandler(rooms)))
+}
+
+func adminAuth(token string, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		have := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(have), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="triples admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r, ps)
+	}
+}
+
+// adminRoomsHandler lists the rooms, leaving out
+// the cards and chat.
+func adminRoomsHandler(rooms *Rooms) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		list := []RoomState{}
+		for k, rm := range rooms.all() {
+			s, ok := rm.inspect()
+			if !ok {
+				continue
+			}
+			s.Game = k[0]
+			s.Cards = nil
+			s.Chat = nil
+			list = append(list, s)
+		}
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Game != list[j].Game {
+				return list[i].Game < list[j].Game
+			}
+			return list[i].Room < list[j].Room
+		})
+		writeJSON(w, list)
+	}
+}
+
+func adminRoomHandler(rooms *Rooms) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		rm := rooms.find(ps.ByName("game"), ps.ByName("room"))
+		if rm == nil {
+			http.Error(w, "no such room", http.StatusNotFound)
+			return
+		}
+		s, ok := rm.inspect()
+		if !ok {
+			http.Error(w, "no such room", http.StatusNotFound)
+			return
+		}
+		s.Game = ps.ByName("game")
+		writeJSON(w, s)
+	}
+}
+
+func adminCloseHandler(rooms *Rooms) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if !rooms.remove(ps.ByName("game"), ps.ByName("room")) {
+			http.Error(w, "no such room", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func adminKickHandler(rooms *Rooms) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		name := r.FormValue("name")
+		if name == "" {
+			http.Error(w, "missing parameter `name`", http.StatusBadRequest)
+			return
+		}
+		rm := rooms.find(ps.ByName("game"), ps.ByName("room"))
+		if rm == nil {
+			http.Error(w, "no such room", http.StatusNotFound)
+			return
+		}
+		n := rm.kick(name)
+		if n == 0 {
+			http.Error(w, "no such player", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, struct {
+			Dropped int `json:"dropped"`
+		}{n})
+	}
+}
+
+func adminBroadcastHandler(rooms *Rooms) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		text := strings.TrimSpace(r.FormValue("text"))
+		if text == "" {
+			http.Error(w, "missing parameter `text`", http.StatusBadRequest)
+			return
+		}
+		all := rooms.all()
+		for _, rm := range all {
+			rm.notice(text)
+		}
+		writeJSON(w, struct {
+			Rooms int `json:"rooms"`
+		}{len(all)})
+	}
+}
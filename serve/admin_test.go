@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestAdmin(t *testing.T) {
+	rooms := newRooms(nil, nil)
+	rm := rooms.get("triplesmulti", "admin")
+	alice, _ := joinRoom(rm, "alice", "")
+	bob, _ := joinRoom(rm, "bob", "")
+
+	r := httprouter.New()
+	admin(r, rooms, "secret")
+	do := func(method, path, token string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("GET", "/api/admin/rooms", "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("no token: have %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if w := do("GET", "/api/admin/rooms", "wrong", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token: have %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	w := do("GET", "/api/admin/rooms", "secret", nil)
+	var list []RoomState
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Game != "triplesmulti" || list[0].Room != "admin" || len(list[0].Clients) != 2 {
+		t.Fatalf("rooms: have %+v", list)
+	}
+
+	w = do("POST", "/api/admin/broadcast", "secret", url.Values{"text": {"restarting soon"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("broadcast: have %d", w.Code)
+	}
+	waitFor(t, bob, func(u Update) bool {
+		n, ok := u.(EventNotice)
+		return ok && n.Text == "restarting soon"
+	})
+
+	w = do("POST", "/api/admin/rooms/triplesmulti/admin/kick", "secret", url.Values{"name": {"alice"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("kick: have %d", w.Code)
+	}
+	waitFor(t, bob, func(u Update) bool {
+		e, ok := u.(EventOnline)
+		return ok && e.Name == "alice" && !e.Present
+	})
+	waitFor(t, alice, func(u Update) bool {
+		n, ok := u.(EventNotice)
+		return ok && n.Text == kickedNotice
+	})
+	if w := do("POST", "/api/admin/rooms/triplesmulti/admin/kick", "secret", url.Values{"name": {"alice"}}); w.Code != http.StatusNotFound {
+		t.Errorf("kick again: have %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	again, _ := joinRoom(rm, "alice", "")
+	waitFor(t, again, func(u Update) bool {
+		n, ok := u.(EventNotice)
+		return ok && n.Text == kickedNotice
+	})
+
+	w = do("GET", "/api/admin/rooms/triplesmulti/admin", "secret", nil)
+	var s RoomState
+	if err := json.NewDecoder(w.Body).Decode(&s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Clients) != 1 || s.Clients[0].Name != "bob" {
+		t.Errorf("after kick: have %+v", s.Clients)
+	}
+
+	if w := do("DELETE", "/api/admin/rooms/triplesmulti/admin", "secret", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("close: have %d", w.Code)
+	}
+	for range bob {
+	}
+	if rooms.find("triplesmulti", "admin") != nil {
+		t.Errorf("room still there after closing")
+	}
+	if w := do("GET", "/api/admin/rooms/triplesmulti/admin", "secret", nil); w.Code != http.StatusNotFound {
+		t.Errorf("closed room: have %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
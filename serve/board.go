@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/robx/triples/serve/render"
+	"github.com/robx/triples/serve/triples"
+)
+
+const (
+	maxBoardScale     = 8
+	maxBoardPositions = 27 * 3 // room for every card of the deck
+)
+
+// boardFromFull lays out the cards of a room snapshot.
+func boardFromFull(f Full) render.Board {
+	b := render.Board{Columns: f.Cols, Rows: f.Rows}
+	for p, c := range f.Cards {
+		b.Cards = append(b.Cards, render.Placed{X: p.X, Y: p.Y, Card: int(c)})
+	}
+	return b
+}
+
+// parseBoard reads a comma-separated list of cards, laid out in
+// columns of three. Empty entries leave a gap. With labels, cards
+// are numbered by their position. Boards are at most 27 columns.
+func parseBoard(s string, labels bool) (render.Board, error) {
+	fs := strings.Split(s, ",")
+	if len(fs) > maxBoardPositions {
+		return render.Board{}, fmt.Errorf("more than %d positions", maxBoardPositions)
+	}
+	b := render.Board{}
+	for i, f := range fs {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		c, err := strconv.Atoi(f)
+		if err != nil {
+			return render.Board{}, err
+		}
+		p := numberPosition(i + 1)
+		pc := render.Placed{X: p.X, Y: p.Y, Card: c}
+		if labels {
+			pc.Label = strconv.Itoa(positionNumber(p))
+		}
+		b.Cards = append(b.Cards, pc)
+	}
+	return b, nil
+}
+
+// positionNumber numbers board positions column by column, from 1.
+func positionNumber(p triples.Position) int {
+	return 3*p.X + p.Y + 1
+}
+
+// numberPosition is the inverse of positionNumber.
+func numberPosition(n int) triples.Position {
+	return triples.Position{X: (n - 1) / 3, Y: (n - 1) % 3}
+}
+
+// boardCards lists the cards of a board in the form read by parseBoard.
+func boardCards(cards map[triples.Position]triples.Card) string {
+	n := 0
+	for p := range cards {
+		if k := positionNumber(p); k > n {
+			n = k
+		}
+	}
+	fs := make([]string, n)
+	for p, c := range cards {
+		fs[positionNumber(p)-1] = strconv.Itoa(int(c))
+	}
+	return strings.Join(fs, ",")
+}
+
+// boardHandler draws either the cards given by the `cards` parameter,
+// or the current board of the room given by `game` and `room`, which
+// takes the room's `password` or an `invite` if it has a password.
+func boardHandler(rooms *Rooms, format string) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		var b render.Board
+		if room := r.FormValue("room"); room != "" {
+			game := r.FormValue("game")
+			if err := rooms.allow(game, room, r.FormValue("password"), r.FormValue("invite"), clientIP(r), time.Now()); err != nil {
+				refuseHTTP(w, err)
+				return
+			}
+			rm := rooms.find(game, room)
+			if rm == nil {
+				http.Error(w, "no such room", http.StatusNotFound)
+				return
+			}
+			f, ok := rm.full()
+			if !ok {
+				http.Error(w, "no such room", http.StatusNotFound)
+				return
+			}
+			b = boardFromFull(f)
+		} else if cards := r.FormValue("cards"); cards != "" {
+			var err error
+			if b, err = parseBoard(cards, r.FormValue("labels") == "1"); err != nil {
+				http.Error(w, "bad parameter `cards`", http.StatusBadRequest)
+				return
+			}
+		} else {
+			http.Error(w, "missing parameter `cards` or `room`", http.StatusBadRequest)
+			return
+		}
+
+		var (
+			buf bytes.Buffer
+			err error
+		)
+		switch format {
+		case "png":
+			scale := 2.0
+			if s := r.FormValue("scale"); s != "" {
+				if scale, err = strconv.ParseFloat(s, 64); err != nil || scale <= 0 || scale > maxBoardScale {
+					http.Error(w, "bad parameter `scale`", http.StatusBadRequest)
+					return
+				}
+			}
+			w.Header().Set("Content-Type", "image/png")
+			err = render.WritePNG(&buf, b, render.Classic, scale)
+		default:
+			w.Header().Set("Content-Type", "image/svg+xml")
+			err = render.WriteSVG(&buf, b, render.Classic)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := buf.WriteTo(w); err != nil {
+			log.Print(err)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestBoardHandler(t *testing.T) {
+	rooms := newRooms(nil, nil)
+	rooms.get("triplesmulti", "here")
+	r := httprouter.New()
+	r.GET("/api/board.svg", boardHandler(rooms, "svg"))
+	r.GET("/api/board.png", boardHandler(rooms, "png"))
+	secret, invite, _ := rooms.create("triplesmulti", "sesame", 0, time.Now())
+	rooms.get("triplesmulti", secret)
+
+	for _, tc := range []struct {
+		url    string
+		status int
+		ctype  string
+	}{
+		{"/api/board.svg?cards=0,1,2,3", http.StatusOK, "image/svg+xml"},
+		{"/api/board.png?cards=80&scale=1", http.StatusOK, "image/png"},
+		{"/api/board.png?game=triplesmulti&room=here", http.StatusOK, "image/png"},
+		{"/api/board.svg?cards=0,x", http.StatusBadRequest, ""},
+		{"/api/board.svg?cards=81", http.StatusBadRequest, ""},
+		{"/api/board.png?cards=1&scale=100", http.StatusBadRequest, ""},
+		{"/api/board.png?cards=" + strings.Repeat(",", 81) + "1", http.StatusBadRequest, ""},
+		{"/api/board.svg", http.StatusBadRequest, ""},
+		{"/api/board.svg?game=triplesmulti&room=elsewhere", http.StatusNotFound, ""},
+		{"/api/board.svg?game=triplesmulti&room=" + secret, http.StatusForbidden, ""},
+		{"/api/board.svg?game=triplesmulti&password=sesame&room=" + secret, http.StatusOK, "image/svg+xml"},
+		{"/api/board.svg?game=triplesmulti&room=" + secret + "&invite=" + url.QueryEscape(invite), http.StatusOK, "image/svg+xml"},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", tc.url, nil))
+		if have, want := w.Code, tc.status; have != want {
+			t.Errorf("%s: have %v, want %v", tc.url, have, want)
+		}
+		if tc.ctype == "" {
+			continue
+		}
+		if have, want := w.Header().Get("Content-Type"), tc.ctype; have != want {
+			t.Errorf("%s: have %v, want %v", tc.url, have, want)
+		}
+	}
+}
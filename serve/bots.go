@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/robx/triples/serve/triples"
+)
+
+// difficulty describes how well a computer player plays.
+type difficulty struct {
+	delay    time.Duration // minimum time to spot a match
+	spread   time.Duration // random extra time
+	wrong    float64       // chance of claiming a non-match
+	careless float64       // chance of not noticing a match is gone
+}
+
+var difficulties = map[string]difficulty{
+	"easy": {
+		delay:    15 * time.Second,
+		spread:   25 * time.Second,
+		wrong:    0.15,
+		careless: 0.3,
+	},
+	"medium": {
+		delay:    8 * time.Second,
+		spread:   12 * time.Second,
+		wrong:    0.08,
+		careless: 0.15,
+	},
+	"hard": {
+		delay:    3 * time.Second,
+		spread:   5 * time.Second,
+		wrong:    0.03,
+		careless: 0.05,
+	},
+}
+
+const defaultDifficulty = "medium"
+
+// botName picks a name for a new computer player that is
+// not yet taken in the room.
+func botName(level string, taken map[string]struct{}) string {
+	for i := 1; ; i++ {
+		name := fmt.Sprintf("Bot %d (%s)", i, level)
+		if _, ok := taken[name]; !ok {
+			return name
+		}
+	}
+}
+
+// botPlayer is a computer opponent. It joins a room like a human
+// client does, follows the board through the room's updates, and
+// claims matches after a delay.
+type botPlayer struct {
+	name      string
+	level     difficulty
+	cards     map[triples.Position]triples.Card
+	matchSize int
+	plan      []triples.Card // cards to claim, nil for no match
+	planned   bool
+	timer     <-chan time.Time
+}
+
+// runBotPlayer plays in room r until stop is closed or the room closes.
+func runBotPlayer(r *Room, name string, level difficulty, stop <-chan struct{}) {
+	b := &botPlayer{
+		name:  name,
+		level: level,
+		cards: map[triples.Position]triples.Card{},
+	}
+	updates, cmds, getId := r.connect(name, "", "")
+	clientId := <-getId
+
+	command := func(c Command) {
+		go func() {
+			select {
+			case cmds <- &cmd{clientId: clientId, command: c}:
+			case <-r.quit:
+			}
+		}()
+	}
+
+	for {
+		select {
+		case u, ok := <-updates:
+			if !ok {
+				return
+			}
+			if b.apply(u) {
+				b.replan()
+			}
+		case <-b.timer:
+			b.timer = nil
+			b.planned = false
+			if b.plan == nil {
+				var cs []triples.Card
+				for _, c := range b.cards {
+					cs = append(cs, c)
+				}
+				command(CmdClaim{Type: ClaimNoMatch, Cards: cs})
+			} else {
+				command(CmdClaim{Type: ClaimMatch, Cards: b.plan})
+			}
+		case <-stop:
+			stop = nil
+			b.timer = nil
+			b.planned = false
+			command(CmdDisconnect{})
+			// keep reading updates until the room lets go of us
+		}
+	}
+}
+
+// apply follows an update, and reports whether
+// there is reason to think again.
+func (b *botPlayer) apply(u Update) bool {
+	switch u := u.(type) {
+	case EventClaimed:
+		return u.Name == b.name
+	case Full:
+		b.cards = map[triples.Position]triples.Card{}
+		for p, c := range u.Cards {
+			b.cards[p] = c
+		}
+		b.matchSize = u.MatchSize
+	case ChangeMatch:
+		for _, p := range u {
+			delete(b.cards, p)
+		}
+	case ChangeDeal:
+		for _, pc := range u {
+			b.cards[pc.Position] = pc.Card
+		}
+	case ChangeMove:
+		for _, m := range u {
+			b.cards[m.To] = b.cards[m.From]
+			delete(b.cards, m.From)
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// replan decides what to claim, and when.
+func (b *botPlayer) replan() {
+	if b.planned && b.plan != nil {
+		if b.stillThere() {
+			return
+		}
+		if rand.Float64() < b.level.careless {
+			// keep the old plan, and be late
+			return
+		}
+	}
+	b.planned = false
+	b.timer = nil
+	if len(b.cards) == 0 || b.matchSize == 0 {
+		return
+	}
+	g := &triples.Game{Type: triples.Triples, Cards: b.cards}
+	if b.matchSize == 4 {
+		g.Type = triples.Quadruples
+	}
+	matches := g.ListMatches()
+	if len(matches) == 0 {
+		if len(b.cards) < 3*conf.Rooms.columns(g.Type) {
+			return
+		}
+		b.plan = nil
+	} else if rand.Float64() < b.level.wrong {
+		b.plan = randomCards(g.ListCards(), b.matchSize)
+	} else {
+		b.plan = matches[rand.Intn(len(matches))]
+	}
+	b.planned = true
+	// more matches are easier to spot
+	d := b.level.delay + time.Duration(rand.Int63n(int64(b.level.spread)+1))
+	d = d * 2 / time.Duration(len(matches)+2)
+	log.Printf("%s plans to claim %v in %s", b.name, b.plan, d)
+	b.timer = time.After(d)
+}
+
+func (b *botPlayer) stillThere() bool {
+	for _, c := range b.plan {
+		found := false
+		for _, cc := range b.cards {
+			if c == cc {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func randomCards(cards []triples.Card, n int) []triples.Card {
+	if len(cards) < n {
+		return nil
+	}
+	out := make([]triples.Card, n)
+	for i, k := range rand.Perm(len(cards))[:n] {
+		out[i] = cards[k]
+	}
+	return out
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/robx/triples/serve/triples"
+)
+
+var perfect = difficulty{delay: time.Millisecond}
+
+func TestBotPlans(t *testing.T) {
+	g := triples.NewGame(triples.Triples)
+	g.Deal()
+	for g.CountMatches() == 0 {
+		g.DealMore()
+	}
+	b := &botPlayer{name: "bot", level: perfect}
+	if !b.apply(makeFull(g, triples.Triples, nil, nil, nil)) {
+		t.Fatal("full update ignored")
+	}
+	b.replan()
+	if !b.planned || b.timer == nil {
+		t.Fatal("no plan")
+	}
+	if !g.IsMatch(b.plan) {
+		t.Errorf("plan %v is no match", b.plan)
+	}
+
+	// the plan survives unrelated changes
+	plan := b.plan
+	b.apply(ChangeDeal{{Position: triples.Position{X: 10, Y: 0}, Card: 80}})
+	b.replan()
+	if have, want := b.plan, plan; !equalCards(have, want) {
+		t.Errorf("have %v, want %v", have, want)
+	}
+}
+
+func equalCards(as, bs []triples.Card) bool {
+	if len(as) != len(bs) {
+		return false
+	}
+	for i := range as {
+		if as[i] != bs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestBotPlaysInRoom(t *testing.T) {
+	difficulties["perfect"] = perfect
+	defer delete(difficulties, "perfect")
+
+	r := newRoom("triplesmulti", "bots", nil, nil)
+	defer r.close()
+	updates, send := joinRoom(r, "Ann", "")
+
+	send(CmdAddBot{Level: "perfect"})
+	waitFor(t, updates, func(u Update) bool {
+		e, ok := u.(EventOnline)
+		return ok && e.Present && e.Name == "Bot 1 (perfect)"
+	})
+	send(CmdStart{})
+	waitFor(t, updates, func(u Update) bool {
+		e, ok := u.(EventClaimed)
+		return ok && e.Name == "Bot 1 (perfect)"
+	})
+	send(CmdRemoveBot{})
+	waitFor(t, updates, func(u Update) bool {
+		e, ok := u.(EventOnline)
+		return ok && !e.Present && e.Name == "Bot 1 (perfect)"
+	})
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/robx/telegram-bot-api"
+	"github.com/robx/triples/serve/triples"
+)
+
+// ChatGames are games played directly in Telegram chats. The bot
+// posts the board as an image with numbered cards, and players claim
+// by replying with card numbers. Only the bot goroutine uses them.
+type ChatGames struct {
+	base  string
+	games map[int64]*chatGame
+}
+
+type chatGame struct {
+	game      *triples.Game
+	messageID int
+	last      string
+	names     map[string]string // by Telegram user ID, as scores are
+}
+
+// player gives the key of a user's score, and the name the chat
+// knows them by, which nobody else in the game has.
+func (cg *chatGame) player(u *tgbotapi.User) (string, string) {
+	id := strconv.Itoa(u.ID)
+	if name, ok := cg.names[id]; ok {
+		return id, name
+	}
+	taken := map[string]bool{}
+	for _, n := range cg.names {
+		taken[n] = true
+	}
+	name := u.FirstName
+	for i := 2; taken[name]; i++ {
+		name = fmt.Sprintf("%s %d", u.FirstName, i)
+	}
+	cg.names[id] = name
+	return id, name
+}
+
+// scores are the players' scores by name.
+func (cg *chatGame) scores() map[string]int {
+	ss := map[string]int{}
+	for id, score := range cg.game.Scores {
+		ss[cg.names[id]] = score
+	}
+	return ss
+}
+
+func newChatGames(base string) *ChatGames {
+	return &ChatGames{
+		base:  base,
+		games: map[int64]*chatGame{},
+	}
+}
+
+const chatHelp = "Reply to the board with the numbers of the cards you've spotted, " +
+	"like \"3 7 11\", or with \"none\" if you think there are none."
+
+// handle processes a message for a chat game,
+// returning false if the message isn't for one.
+func (cs *ChatGames) handle(bot BotClient, m *tgbotapi.Message) bool {
+	if m.Chat == nil || m.From == nil {
+		return false
+	}
+	words := strings.Fields(m.Text)
+	if len(words) == 0 {
+		return false
+	}
+	// in groups, commands may be addressed as /play@TriplesBot
+	command := strings.SplitN(words[0], "@", 2)[0]
+	switch command {
+	case "/play":
+		typ := triples.Triples
+		if len(words) > 1 && words[1] == "quadruples" {
+			typ = triples.Quadruples
+		}
+		cs.start(bot, m.Chat.ID, typ)
+		return true
+	case "/stop":
+		if cg := cs.games[m.Chat.ID]; cg != nil {
+			cs.finish(bot, m.Chat.ID, cg)
+		}
+		return true
+	}
+	cg := cs.games[m.Chat.ID]
+	if cg == nil || m.ReplyToMessage == nil || m.ReplyToMessage.MessageID != cg.messageID {
+		return false
+	}
+	numbers, nomatch, ok := parseClaim(m.Text)
+	if !ok {
+		return false
+	}
+	id, name := cg.player(m.From)
+	g := cg.game
+	if nomatch {
+		res, score, _ := g.ClaimNoMatch(id, g.ListCards())
+		switch res {
+		case triples.Correct:
+			cg.last = fmt.Sprintf("%s: right, no %s! (%d)", name, matchName(g.Type), score)
+		case triples.Wrong:
+			cg.last = fmt.Sprintf("%s: wrong, there are %d. (%d)", name, g.CountMatches(), score)
+		default:
+			return true
+		}
+	} else {
+		if len(numbers) != g.Type.MatchSize() {
+			send(bot, tgbotapi.NewMessage(m.Chat.ID, fmt.Sprintf("Pick %d cards. %s", g.Type.MatchSize(), chatHelp)))
+			return true
+		}
+		var cards []triples.Card
+		for _, n := range numbers {
+			c, ok := g.Cards[numberPosition(n)]
+			if !ok {
+				return true
+			}
+			cards = append(cards, c)
+		}
+		res, score, _ := g.ClaimMatch(id, cards)
+		switch res {
+		case triples.Correct:
+			cg.last = fmt.Sprintf("%s: %s! (%d)", name, matchName(g.Type), score)
+			if !g.GameOver() {
+				g.Compact()
+				g.Deal()
+			}
+		case triples.Wrong:
+			cg.last = fmt.Sprintf("%s: that's no %s. (%d)", name, matchName(g.Type), score)
+		default:
+			return true
+		}
+	}
+	if g.GameOver() {
+		cs.finish(bot, m.Chat.ID, cg)
+		return true
+	}
+	cs.update(bot, m.Chat.ID, cg)
+	return true
+}
+
+func (cs *ChatGames) start(bot BotClient, chatID int64, typ triples.Type) {
+	cg := cs.games[chatID]
+	if cg == nil || cg.game.GameOver() {
+		g := triples.NewGame(typ)
+		g.Deal()
+		cg = &chatGame{game: g, last: chatHelp, names: map[string]string{}}
+		cs.games[chatID] = cg
+	}
+	// post the board again, even if a game is running,
+	// so it can be found at the bottom of the chat
+	msg := tgbotapi.NewMessage(chatID, cs.text(cg))
+	msg.ParseMode = tgbotapi.ModeHTML
+	sent, err := bot.Send(msg)
+	if err != nil {
+		log.Printf("posting board to %d: %s", chatID, err)
+		return
+	}
+	cg.messageID = sent.MessageID
+}
+
+func (cs *ChatGames) update(bot BotClient, chatID int64, cg *chatGame) {
+	edit := tgbotapi.NewEditMessageText(chatID, cg.messageID, cs.text(cg))
+	edit.ParseMode = tgbotapi.ModeHTML
+	send(bot, edit)
+}
+
+func (cs *ChatGames) finish(bot BotClient, chatID int64, cg *chatGame) {
+	delete(cs.games, chatID)
+	cs.update(bot, chatID, cg)
+	send(bot, tgbotapi.NewMessage(chatID, formatResults(cg.scores())))
+}
+
+// text describes the game, with a link to the board image
+// that Telegram shows as a preview.
+func (cs *ChatGames) text(cg *chatGame) string {
+	g := cg.game
+	v := url.Values{}
+	v.Add("cards", boardCards(g.Cards))
+	v.Add("labels", "1")
+	img := cs.base + "/api/board.png?" + v.Encode()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "<a href=\"%s\">&#8203;</a><b>%s</b>, %d cards left in the deck",
+		html.EscapeString(img), gameTitle(g.Type), g.DeckSize())
+	if len(g.Scores) > 0 {
+		b.WriteString("\n")
+		b.WriteString(html.EscapeString(formatScores(cg.scores())))
+	}
+	fmt.Fprintf(&b, "\n\n%s", html.EscapeString(cg.last))
+	return b.String()
+}
+
+func gameTitle(typ triples.Type) string {
+	if typ == triples.Quadruples {
+		return "Quadruples"
+	}
+	return "Triples"
+}
+
+func matchName(typ triples.Type) string {
+	if typ == triples.Quadruples {
+		return "quadruple"
+	}
+	return "triple"
+}
+
+// parseClaim reads a reply like "3 7 11" or "none". Other
+// messages are not claims.
+func parseClaim(text string) (numbers []int, nomatch, ok bool) {
+	fs := strings.FieldsFunc(text, func(r rune) bool {
+		return r == ' ' || r == ',' || r == '\n'
+	})
+	if len(fs) == 1 && strings.EqualFold(fs[0], "none") {
+		return nil, true, true
+	}
+	seen := map[int]bool{}
+	for _, f := range fs {
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 1 || seen[n] {
+			return nil, false, false
+		}
+		seen[n] = true
+		numbers = append(numbers, n)
+	}
+	return numbers, false, len(numbers) > 0
+}
+
+func send(bot BotClient, c tgbotapi.Chattable) {
+	if _, err := bot.Send(c); err != nil {
+		log.Printf("send: %s", err)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/robx/telegram-bot-api"
+	"github.com/robx/triples/serve/triples"
+)
+
+func TestParseClaim(t *testing.T) {
+	for _, tc := range []struct {
+		text    string
+		numbers []int
+		nomatch bool
+		ok      bool
+	}{
+		{"3 7 11", []int{3, 7, 11}, false, true},
+		{"3,7, 11", []int{3, 7, 11}, false, true},
+		{"None", nil, true, true},
+		{"3 3 11", nil, false, false},
+		{"0 1 2", nil, false, false},
+		{"hello there", nil, false, false},
+		{"", nil, false, false},
+	} {
+		numbers, nomatch, ok := parseClaim(tc.text)
+		if !reflect.DeepEqual(numbers, tc.numbers) || nomatch != tc.nomatch || ok != tc.ok {
+			t.Errorf("%q: have %v %v %v, want %v %v %v", tc.text,
+				numbers, nomatch, ok, tc.numbers, tc.nomatch, tc.ok)
+		}
+	}
+}
+
+// chatMessage is a message from Ann, in reply to the first
+// message the fake bot sent.
+func chatMessage(text string) *tgbotapi.Message {
+	return &tgbotapi.Message{
+		Chat:           &tgbotapi.Chat{ID: 42},
+		From:           &tgbotapi.User{ID: 7, FirstName: "Ann"},
+		ReplyToMessage: &tgbotapi.Message{MessageID: 1},
+		Text:           text,
+	}
+}
+
+// findMatch returns the numbers of a match on the board, if any.
+func findMatch(g *triples.Game) []int {
+	var ns []int
+	for p := range g.Cards {
+		ns = append(ns, positionNumber(p))
+	}
+	card := func(n int) triples.Card { return g.Cards[numberPosition(n)] }
+	for i := range ns {
+		for j := i + 1; j < len(ns); j++ {
+			for k := j + 1; k < len(ns); k++ {
+				if triples.IsTriple(card(ns[i]), card(ns[j]), card(ns[k])) {
+					return []int{ns[i], ns[j], ns[k]}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func TestChatGame(t *testing.T) {
+	var (
+		bot   = newFakeBot()
+		chats = newChatGames("https://example.com/triples")
+	)
+	if chats.handle(bot, chatMessage("1 2 3")) {
+		t.Error("claim without a game")
+	}
+	if !chats.handle(bot, chatMessage("/play@TriplesBot")) {
+		t.Fatal("/play not handled")
+	}
+	cg := chats.games[42]
+	if cg == nil {
+		t.Fatal("no game started")
+	}
+	board, ok := bot.Sent()[0].(tgbotapi.MessageConfig)
+	if !ok {
+		t.Fatalf("have %T, want MessageConfig", bot.Sent()[0])
+	}
+	if !strings.Contains(board.Text, "https://example.com/triples/api/board.png?cards=") {
+		t.Errorf("missing board link: %s", board.Text)
+	}
+	if have, want := cg.messageID, 1; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+
+	if chats.handle(bot, chatMessage("nice weather")) {
+		t.Error("chatter handled as a claim")
+	}
+	aside := chatMessage("10")
+	aside.ReplyToMessage = nil
+	if chats.handle(bot, aside) {
+		t.Error("number not in reply to the board handled as a claim")
+	}
+
+	// make sure there is a triple on the board
+	for findMatch(cg.game) == nil {
+		cg.game.DealMore()
+	}
+	if !chats.handle(bot, chatMessage("none")) {
+		t.Error("nomatch claim not handled")
+	}
+	if have, want := cg.game.Scores["7"], -1; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+
+	m := findMatch(cg.game)
+	if !chats.handle(bot, chatMessage(fmt.Sprintf("%d %d %d", m[0], m[1], m[2]))) {
+		t.Error("claim not handled")
+	}
+	if have, want := cg.game.Scores["7"], 0; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	sent := bot.Sent()
+	edit, ok := sent[len(sent)-1].(tgbotapi.EditMessageTextConfig)
+	if !ok {
+		t.Fatalf("have %T, want EditMessageTextConfig", sent[len(sent)-1])
+	}
+	if have, want := edit.MessageID, cg.messageID; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	if !strings.Contains(edit.Text, "Ann: triple!") {
+		t.Errorf("unexpected board text: %s", edit.Text)
+	}
+
+	// another Ann gets a score of her own
+	for findMatch(cg.game) == nil {
+		cg.game.DealMore()
+	}
+	other := chatMessage("none")
+	other.From = &tgbotapi.User{ID: 8, FirstName: "Ann"}
+	chats.handle(bot, other)
+	if have, want := cg.game.Scores["8"], -1; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	if have, want := cg.scores(), map[string]int{"Ann": 0, "Ann 2": -1}; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %v, want %v", have, want)
+	}
+
+	chats.handle(bot, chatMessage("/stop"))
+	if chats.games[42] != nil {
+		t.Error("game not stopped")
+	}
+	sent = bot.Sent()
+	if res, ok := sent[len(sent)-1].(tgbotapi.MessageConfig); !ok || !strings.HasPrefix(res.Text, "Game over!") {
+		t.Errorf("have %+v, want results", sent[len(sent)-1])
+	}
+}
+
+func TestBoardCards(t *testing.T) {
+	cards := map[triples.Position]triples.Card{{X: 0, Y: 0}: 5, {X: 1, Y: 1}: 7}
+	if have, want := boardCards(cards), "5,,,,7"; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	b, err := parseBoard("5,,,,7", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(b.Cards), 2; have != want {
+		t.Fatalf("have %v, want %v", have, want)
+	}
+	if have, want := b.Cards[1].Label, "5"; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+}
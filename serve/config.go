@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/robx/triples/serve/triples"
+)
+
+// Config is the server's configuration. It is read from a JSON
+// file given with -config, and command-line flags override it.
+type Config struct {
+	Listen        string     `json:"listen"`
+	Static        string     `json:"static"`  // client directory, instead of the embedded one
+	Base          string     `json:"base"`    // public URL or path of the app, like https://example.com/triples
+	Origins       []string   `json:"origins"` // other sites the client may be served from, like https://cdn.example.com, or "*"
+	Bot           bool       `json:"bot"`
+	DebugBot      bool       `json:"debugbot"`
+	TelegramToken string     `json:"telegramToken"` // or TELEGRAM_TOKEN
+	Queue         string     `json:"queue"`
+	Puzzles       string     `json:"puzzles"`
+	Ratings       string     `json:"ratings"`
+	Admin         string     `json:"admin"`
+	AdminToken    string     `json:"adminToken"` // or ADMIN_TOKEN
+	Games         []string   `json:"games"`      // the enabled variants
+	Rooms         RoomConfig `json:"rooms"`
+}
+
+// RoomConfig are the settings of multiplayer rooms.
+// Zero limits mean no limit.
+type RoomConfig struct {
+	CloseDelay        Duration `json:"closeDelay"`     // how long empty rooms stay open
+	AnimationDelay    Duration `json:"animationDelay"` // between board changes
+	MaxRooms          int      `json:"maxRooms"`
+	MaxPlayers        int      `json:"maxPlayers"` // per room, bots included
+	MaxBots           int      `json:"maxBots"`
+	TriplesColumns    int      `json:"triplesColumns"`
+	QuadruplesColumns int      `json:"quadruplesColumns"`
+	PingInterval      Duration `json:"pingInterval"` // 0 for no pings
+	PongTimeout       Duration `json:"pongTimeout"`  // how late a pong may be
+	WriteTimeout      Duration `json:"writeTimeout"`
+	ResumeBuffer      int      `json:"resumeBuffer"` // updates kept for reconnecting clients
+	MaxConnsPerIP     int      `json:"maxConnsPerIP"`
+	MaxMessageSize    int      `json:"maxMessageSize"` // in bytes, for commands
+	ClaimBurst        int      `json:"claimBurst"`     // claims a player may make in any claimWindow
+	ClaimWindow       Duration `json:"claimWindow"`
+	InviteExpiry      Duration `json:"inviteExpiry"` // for rooms made with POST /api/rooms
+}
+
+// Duration is a time.Duration written like "30s" in JSON.
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(bs []byte) error {
+	var s string
+	if err := json.Unmarshal(bs, &s); err != nil {
+		return fmt.Errorf("duration should be a string like \"30s\"")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// conf is the configuration in effect.
+var conf = defaultConfig()
+
+func defaultConfig() Config {
+	return Config{
+		Listen:  ":8080",
+		Bot:     true,
+		Queue:   "scorequeue.json",
+		Puzzles: "puzzles.json",
+		Ratings: "ratings.json",
+		Games:   append(append([]string{}, games...), multigames...),
+		Rooms: RoomConfig{
+			CloseDelay:        Duration{30 * time.Second},
+			AnimationDelay:    Duration{250 * time.Millisecond},
+			MaxBots:           4,
+			TriplesColumns:    triples.Triples.DefaultColumns(),
+			QuadruplesColumns: triples.Quadruples.DefaultColumns(),
+			PingInterval:      Duration{20 * time.Second},
+			PongTimeout:       Duration{10 * time.Second},
+			WriteTimeout:      Duration{10 * time.Second},
+			ResumeBuffer:      64,
+			MaxConnsPerIP:     20,
+			MaxMessageSize:    4096,
+			ClaimBurst:        10,
+			ClaimWindow:       Duration{5 * time.Second},
+			InviteExpiry:      Duration{24 * time.Hour},
+		},
+	}
+}
+
+var (
+	configPath  = flag.String("config", "", "JSON config file")
+	printConfig = flag.Bool("printconfig", false, "print the effective configuration and exit")
+)
+
+func init() {
+	flag.StringVar(&conf.Listen, "listen", conf.Listen, "http listen")
+	flag.StringVar(&conf.Static, "static", conf.Static, "serve the client from this directory instead of the embedded one")
+	flag.BoolVar(&conf.DebugBot, "debugbot", conf.DebugBot, "debug logs for the Telegram bot")
+	flag.StringVar(&conf.Base, "base", conf.Base, "public URL or path the app is served under")
+	flag.BoolVar(&conf.Bot, "bot", conf.Bot, "run the telegram bot")
+	flag.StringVar(&conf.Queue, "queue", conf.Queue, "file for undelivered Telegram scores")
+	flag.StringVar(&conf.Puzzles, "puzzles", conf.Puzzles, "file for daily puzzle times")
+	flag.StringVar(&conf.Ratings, "ratings", conf.Ratings, "file for player ratings")
+	flag.StringVar(&conf.Admin, "admin", conf.Admin, "separate http listen for the admin API, if any")
+}
+
+// configure reads the config file and the environment, and lets
+// the command line have the last word. It must run after flag.Parse.
+func configure() error {
+	if *configPath != "" {
+		if err := conf.load(*configPath); err != nil {
+			return err
+		}
+		// the flags win over the file
+		flag.Parse()
+	}
+	if t := os.Getenv("TELEGRAM_TOKEN"); t != "" {
+		conf.TelegramToken = t
+	}
+	if t := os.Getenv("ADMIN_TOKEN"); t != "" {
+		conf.AdminToken = t
+	}
+	conf.Base = strings.TrimSuffix(conf.Base, "/")
+	if err := conf.validate(); err != nil {
+		return fmt.Errorf("bad configuration: %s", err)
+	}
+	games = enabled(games, conf.Games)
+	multigames = enabled(multigames, conf.Games)
+	return nil
+}
+
+func (c *Config) load(path string) error {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	d := json.NewDecoder(bytes.NewReader(bs))
+	d.DisallowUnknownFields()
+	if err := d.Decode(c); err != nil {
+		return fmt.Errorf("reading config %s: %s", path, err)
+	}
+	return nil
+}
+
+func (c Config) validate() error {
+	if c.Listen == "" {
+		return fmt.Errorf("listen: missing")
+	}
+	base, err := url.Parse(c.Base)
+	if err != nil {
+		return fmt.Errorf("base: %s", err)
+	}
+	if base.RawQuery != "" || base.Fragment != "" {
+		return fmt.Errorf("base: no query or fragment, please")
+	}
+	for _, o := range c.Origins {
+		if o == "*" {
+			continue
+		}
+		u, err := url.Parse(o)
+		if err != nil || u.Scheme == "" || u.Host == "" || strings.TrimSuffix(u.Path, "/") != "" {
+			return fmt.Errorf("origins: %q should be like https://example.com, or *", o)
+		}
+	}
+	if c.Bot && c.TelegramToken == "" {
+		return fmt.Errorf("bot: needs a telegramToken or TELEGRAM_TOKEN")
+	}
+	if c.Bot && (base.Scheme == "" || base.Host == "") {
+		return fmt.Errorf("bot: needs a full base URL for Telegram links, not %q", c.Base)
+	}
+	if c.Admin != "" && c.AdminToken == "" {
+		return fmt.Errorf("admin: needs an adminToken or ADMIN_TOKEN")
+	}
+	if len(c.Games) == 0 {
+		return fmt.Errorf("games: none enabled")
+	}
+	known := map[string]bool{}
+	for _, g := range defaultConfig().Games {
+		known[g] = true
+	}
+	for _, g := range c.Games {
+		if !known[g] {
+			return fmt.Errorf("games: unknown game %q", g)
+		}
+	}
+	r := c.Rooms
+	if r.CloseDelay.Duration < 0 {
+		return fmt.Errorf("rooms.closeDelay: negative")
+	}
+	if r.AnimationDelay.Duration < 0 || r.AnimationDelay.Duration > 5*time.Second {
+		return fmt.Errorf("rooms.animationDelay: should be between 0s and 5s")
+	}
+	if r.MaxRooms < 0 || r.MaxPlayers < 0 || r.MaxBots < 0 || r.ResumeBuffer < 0 ||
+		r.MaxConnsPerIP < 0 || r.MaxMessageSize < 0 || r.ClaimBurst < 0 {
+		return fmt.Errorf("rooms: limits can't be negative")
+	}
+	if r.ClaimBurst > 0 && r.ClaimWindow.Duration <= 0 {
+		return fmt.Errorf("rooms.claimWindow: should be positive")
+	}
+	if r.InviteExpiry.Duration <= 0 {
+		return fmt.Errorf("rooms.inviteExpiry: should be positive")
+	}
+	if r.PingInterval.Duration < 0 {
+		return fmt.Errorf("rooms.pingInterval: negative")
+	}
+	if r.PingInterval.Duration > 0 && r.PongTimeout.Duration <= 0 {
+		return fmt.Errorf("rooms.pongTimeout: should be positive")
+	}
+	if r.WriteTimeout.Duration <= 0 {
+		return fmt.Errorf("rooms.writeTimeout: should be positive")
+	}
+	for name, cols := range map[string]int{
+		"triplesColumns":    r.TriplesColumns,
+		"quadruplesColumns": r.QuadruplesColumns,
+	} {
+		if cols < 3 || cols > 9 {
+			return fmt.Errorf("rooms.%s: should be between 3 and 9", name)
+		}
+	}
+	return nil
+}
+
+// print writes the configuration as a config file,
+// leaving out the secrets.
+func (c Config) print(w io.Writer) error {
+	for _, t := range []*string{&c.TelegramToken, &c.AdminToken} {
+		if *t != "" {
+			*t = "(hidden)"
+		}
+	}
+	bs, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", bs)
+	return err
+}
+
+// prefix is the path the app is served under,
+// without a trailing slash.
+func (c Config) prefix() string {
+	u, err := url.Parse(c.Base)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(u.Path, "/")
+}
+
+// columns is the width of a full board in rooms.
+func (r RoomConfig) columns(t triples.Type) int {
+	if t == triples.Quadruples {
+		return r.QuadruplesColumns
+	}
+	return r.TriplesColumns
+}
+
+// enabled keeps the games that are in on.
+func enabled(games, on []string) []string {
+	var out []string
+	for _, g := range games {
+		for _, o := range on {
+			if g == o {
+				out = append(out, g)
+				break
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(path, []byte(`{
+		"listen": ":9090",
+		"bot": false,
+		"games": ["triples", "triplesmulti"],
+		"rooms": {"closeDelay": "1m", "maxPlayers": 8}
+	}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := defaultConfig()
+	if err := c.load(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Listen != ":9090" || c.Bot || len(c.Games) != 2 {
+		t.Errorf("have %+v", c)
+	}
+	if have, want := c.Rooms.CloseDelay.Duration, time.Minute; have != want {
+		t.Errorf("closeDelay: have %s, want %s", have, want)
+	}
+	// the rest keeps its defaults
+	if c.Static != "" || c.Rooms.MaxBots != 4 || c.Rooms.AnimationDelay.Duration != 250*time.Millisecond {
+		t.Errorf("defaults lost: %+v", c)
+	}
+	if have, want := enabled(multigames, c.Games), []string{"triplesmulti"}; len(have) != 1 || have[0] != want[0] {
+		t.Errorf("enabled: have %v, want %v", have, want)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`{"lisen": ":9090"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.load(path); err == nil || !strings.Contains(err.Error(), "lisen") {
+		t.Errorf("misspelt field: have %v", err)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	for _, c := range []struct {
+		change func(*Config)
+		want   string
+	}{
+		{func(c *Config) { c.TelegramToken = "" }, "bot"},
+		{func(c *Config) { c.Games = []string{"chess"} }, "unknown game"},
+		{func(c *Config) { c.Games = nil }, "none enabled"},
+		{func(c *Config) { c.Rooms.MaxRooms = -1 }, "negative"},
+		{func(c *Config) { c.Rooms.TriplesColumns = 20 }, "triplesColumns"},
+		{func(c *Config) { c.Admin = ":8081" }, "admin"},
+		{func(c *Config) { c.Base = "/triples" }, "full base URL"},
+		{func(c *Config) { c.Base = "https://example.com/?x=1" }, "query"},
+		{func(c *Config) { c.Origins = []string{"cdn.example.com"} }, "origins"},
+	} {
+		cfg := defaultConfig()
+		cfg.TelegramToken = "token"
+		cfg.Base = "https://example.com/triples"
+		c.change(&cfg)
+		if err := cfg.validate(); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("have %v, want an error about %s", err, c.want)
+		}
+	}
+}
+
+func TestPrintConfig(t *testing.T) {
+	c := defaultConfig()
+	c.TelegramToken = "123:secret"
+	var buf bytes.Buffer
+	if err := c.print(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "secret") {
+		t.Errorf("token printed: %s", buf.String())
+	}
+	if !strings.Contains(buf.String(), `"closeDelay": "30s"`) {
+		t.Errorf("closeDelay missing: %s", buf.String())
+	}
+	if c.TelegramToken != "123:secret" {
+		t.Errorf("printing changed the config")
+	}
+}
+
+func TestRoomFull(t *testing.T) {
+	defer func(c RoomConfig) { conf.Rooms = c }(conf.Rooms)
+	conf.Rooms.MaxPlayers = 2
+
+	r := newRoom("triplesmulti", "full", nil, nil)
+	defer r.close()
+	joinRoom(r, "alice", "")
+	joinRoom(r, "bob", "")
+	carol, _ := joinRoom(r, "carol", "")
+	waitFor(t, carol, func(u Update) bool {
+		n, ok := u.(EventNotice)
+		return ok && n.Text == roomFullNotice
+	})
+	// players who are already there can open another connection
+	alice, _ := joinRoom(r, "alice", "")
+	waitFor(t, alice, func(u Update) bool {
+		_, ok := u.(Full)
+		return ok
+	})
+}
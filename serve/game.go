@@ -5,15 +5,18 @@ import (
 	"math/rand"
 	"net/http"
 	"sort"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/robx/triples/serve/triples"
 	"gopkg.in/edn.v1"
 )
 
 const (
-	closeDelay = 30 * time.Second
+	roomFullNotice  = "This room is full."
+	nameTakenNotice = "Someone else is playing under this name."
 )
 
 func init() {
@@ -23,54 +26,95 @@ func init() {
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
+	Subprotocols:    []string{subprotocolPrefix + "edn", subprotocolPrefix + "json"},
+	CheckOrigin:     checkOrigin,
 }
 
 type Rooms struct {
-	mu    sync.Mutex
-	rooms map[[2]string]*Room
+	mu        sync.Mutex
+	rooms     map[[2]string]*Room
+	reserved  map[[2]string]*reservation
+	events    map[string]*eventConn // by token
+	ips       map[string]int        // connections by client address
+	creates   *rateLimit            // by client address
+	guesses   *rateLimit            // by client address
+	results   ResultHandler
+	ratings   *Ratings
+	inviteKey [32]byte
 }
 
-func newRooms() *Rooms {
+func newRooms(results ResultHandler, ratings *Ratings) *Rooms {
 	return &Rooms{
-		rooms: map[[2]string]*Room{},
+		rooms:     map[[2]string]*Room{},
+		reserved:  map[[2]string]*reservation{},
+		inviteKey: genKey(),
+		events:    map[string]*eventConn{},
+		ips:       map[string]int{},
+		creates:   newRateLimit(createBurst, createWindow),
+		guesses:   newRateLimit(guessBurst, guessWindow),
+		results:   results,
+		ratings:   ratings,
 	}
 }
 
-func (rs *Rooms) Serve(game, room, name string, w http.ResponseWriter, req *http.Request) {
+func (rs *Rooms) Serve(game, room, name, key, team string, w http.ResponseWriter, req *http.Request) {
+	ip := clientIP(req)
+	if !rs.admit(ip) {
+		http.Error(w, "too many connections", http.StatusTooManyRequests)
+		return
+	}
+	defer rs.leave(ip)
 	r := rs.get(game, room)
-	r.Serve(name, w, req)
-	rs.release(game, room)
+	if r == nil {
+		http.Error(w, "too many rooms", http.StatusServiceUnavailable)
+		return
+	}
+	r.Serve(name, key, team, w, req)
+	rs.release(game, room, r)
 }
 
+// get finds or opens a room, or returns nil if
+// there are too many rooms open.
 func (rs *Rooms) get(game, room string) *Room {
 	key := [2]string{game, room}
 	rs.mu.Lock()
 	defer rs.mu.Unlock()
 	if _, ok := rs.rooms[key]; !ok {
-		rs.rooms[key] = newRoom(game, room)
+		if max := conf.Rooms.MaxRooms; max > 0 && len(rs.rooms) >= max {
+			return nil
+		}
+		rs.rooms[key] = openRoom(game, room, rs.maxPlayers(game, room), rs.results, rs.ratings)
 	}
 	rs.rooms[key].count += 1
 	return rs.rooms[key]
 }
 
-func (rs *Rooms) release(game, room string) {
+// find returns an existing room, or nil.
+func (rs *Rooms) find(game, room string) *Room {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.rooms[[2]string{game, room}]
+}
+
+func (rs *Rooms) release(game, room string, r *Room) {
 	key := [2]string{game, room}
 	rs.mu.Lock()
 	defer rs.mu.Unlock()
 	rm := rs.rooms[key]
-	if rm == nil {
+	if rm != r {
+		// closed by force, maybe already replaced
 		return
 	}
 	rm.count -= 1
 	if rm.count <= 0 {
-		go rs.maybeClose(key)
+		go rs.maybeClose(key, conf.Rooms.CloseDelay.Duration)
 	}
 }
 
 // maybeClose closes an empty room after a delay,
 // assuming it is still empty (or accidentally again empty).
-func (rs *Rooms) maybeClose(key [2]string) {
-	time.Sleep(closeDelay)
+func (rs *Rooms) maybeClose(key [2]string, delay time.Duration) {
+	time.Sleep(delay)
 	rs.mu.Lock()
 	defer rs.mu.Unlock()
 	rm := rs.rooms[key]
@@ -82,15 +126,26 @@ func (rs *Rooms) maybeClose(key [2]string) {
 	}
 	delete(rs.rooms, key)
 	rm.close()
+	if res := rs.reserved[key]; res != nil {
+		res.used = time.Now()
+	}
 }
 
 type Room struct {
-	game     int
+	game     triples.Type
 	room     string
 	quit     chan struct{}
 	connects chan *client
 	cmds     chan *cmd
+	fulls    chan chan<- Update
+	inspects chan chan<- RoomState
+	kicks    chan kick
+	notices  chan string
 	count    int
+	max      int // players, bots included
+	results  ResultHandler
+	ratings  *Ratings
+	stream   string // tells this room's updates from those of earlier rooms
 }
 
 type cmd struct {
@@ -100,18 +155,35 @@ type cmd struct {
 
 type client struct {
 	name    string
+	key     string
+	team    string
 	updates chan<- Update
 	sendId  chan<- int
+	rtt     time.Duration
+	session
+}
+
+// session is how a connection follows the room's updates.
+type session struct {
+	numbered bool   // wants Sequenced updates
+	pings    bool   // wants EventPing
+	stream   string // the stream it is resuming, if any
+	resume   int    // the last update it saw of that stream
 }
 
 func (c client) Name() string {
 	return c.name
 }
 
-func newRoom(game, room string) *Room {
-	gm := GameTriples
+func newRoom(game, room string, results ResultHandler, ratings *Ratings) *Room {
+	return openRoom(game, room, conf.Rooms.MaxPlayers, results, ratings)
+}
+
+// openRoom starts a room with a seat limit, 0 for none.
+func openRoom(game, room string, maxPlayers int, results ResultHandler, ratings *Ratings) *Room {
+	gm := triples.Triples
 	if game == "quadruplesmulti" {
-		gm = GameQuadruples
+		gm = triples.Quadruples
 	}
 	r := &Room{
 		game:     gm,
@@ -119,293 +191,40 @@ func newRoom(game, room string) *Room {
 		quit:     make(chan struct{}),
 		connects: make(chan *client),
 		cmds:     make(chan *cmd),
+		fulls:    make(chan chan<- Update),
+		inspects: make(chan chan<- RoomState),
+		kicks:    make(chan kick),
+		notices:  make(chan string),
+		max:      maxPlayers,
+		results:  results,
+		ratings:  ratings,
+		stream:   strconv.FormatInt(rand.Int63(), 36),
 	}
 	go r.loop()
 	return r
 }
 
-const (
-	GameTriples    = 0
-	GameQuadruples = 1
-)
-
-var (
-	defaultColumns = [2]int{4, 3}
-	matchSize      = [2]int{3, 4}
-)
-
-type Game struct {
-	Type           int
-	DefaultColumns int
-	Deck           []int
-	Cards          map[Position]int
-	Scores         map[string]int
-	ClaimedNoMatch bool
-}
-
-func newGame(gameType int) *Game {
-	return &Game{
-		Type:           gameType,
-		DefaultColumns: defaultColumns[gameType],
-		Deck:           rand.Perm(81),
-		Cards:          map[Position]int{},
-		Scores:         map[string]int{},
-	}
-}
-
-func (g *Game) deckSize() int {
-	return len(g.Deck)
-}
-
-func (g *Game) add(player string) {
-	g.Scores[player] = g.Scores[player]
-}
-
-func (g *Game) findCard(c int) (Position, bool) {
-	for p, cc := range g.Cards {
-		if cc == c {
-			return p, true
-		}
-	}
-	return Position{}, false
-}
-
-func isTriple(x, y, z int) bool {
-	for i := 0; i < 4; i++ {
-		if (x+y+z)%3 != 0 {
-			return false
-		}
-		x /= 3
-		y /= 3
-		z /= 3
-	}
-	return true
-}
-
-func isQuadruple(x, y, z, w int) bool {
-	missing := func(a, b int) int {
-		c := 0
-		f := 1
-		for i := 0; i < 4; i++ {
-			c += f * ((-a - b) % 3)
-			f *= 3
-		}
-		return c
-	}
-	return missing(x, y) == missing(z, w) || missing(x, z) == missing(y, w) || missing(x, w) == missing(y, z)
-}
-
-func (g *Game) listCards() []int {
-	var cs []int
-	for _, c := range g.Cards {
-		cs = append(cs, c)
-	}
-	return cs
-}
-
-func (g *Game) countMatches() int {
-	var (
-		count = 0
-		cards = g.listCards()
-	)
-	switch g.Type {
-	case GameQuadruples:
-		for i := 0; i < len(cards); i++ {
-			for j := i + 1; j < len(cards); j++ {
-				for k := j + 1; k < len(cards); k++ {
-					for l := k + 1; l < len(cards); l++ {
-						if isQuadruple(cards[i], cards[j], cards[k], cards[l]) {
-							count++
-						}
-					}
-				}
-			}
-		}
-	default:
-		for i := 0; i < len(cards); i++ {
-			for j := i + 1; j < len(cards); j++ {
-				for k := j + 1; k < len(cards); k++ {
-					if isTriple(cards[i], cards[j], cards[k]) {
-						count++
-					}
-				}
-			}
-		}
-	}
-	return count
-}
-
-func (g *Game) gameover() bool {
-	if len(g.Deck) > 0 {
-		return false
-	}
-	return g.countMatches() == 0
-}
-
-func (g *Game) isMatch(cards []int) bool {
-	switch g.Type {
-	case GameQuadruples:
-		return len(cards) == 4 && isQuadruple(cards[0], cards[1], cards[2], cards[3])
-	default:
-		return len(cards) == 3 && isTriple(cards[0], cards[1], cards[2])
-	}
-}
-
-func (g *Game) claimMatch(name string, cards []int) (ResultType, int, Update) {
-	var ps []Position
-	if g.gameover() {
-		return ResultLate, g.Scores[name], nil
-	}
-	for _, c := range cards {
-		if p, ok := g.findCard(c); !ok {
-			return ResultLate, g.Scores[name], nil
-		} else {
-			ps = append(ps, p)
-		}
-	}
-	if g.isMatch(cards) {
-		for _, p := range ps {
-			delete(g.Cards, p)
-		}
-		g.Scores[name] += 1
-		return ResultCorrect, g.Scores[name], ChangeMatch(ps)
-	}
-	g.Scores[name] -= 1
-	return ResultWrong, g.Scores[name], nil
-}
-
-func (g *Game) claimNomatch(name string, cards []int) (ResultType, int, Update) {
-	if g.gameover() || len(cards) < 3*g.DefaultColumns || g.ClaimedNoMatch {
-		return ResultLate, g.Scores[name], nil
-	}
-	cs := g.listCards()
-	equal := func(as, bs []int) bool {
-		if len(as) != len(bs) {
-			return false
-		}
-		sort.Ints(as)
-		sort.Ints(bs)
-		for i := 0; i < len(as); i++ {
-			if as[i] != bs[i] {
-				return false
-			}
-		}
-		return true
-	}
-	if !equal(cs, cards) {
-		return ResultLate, g.Scores[name], nil
-	}
-	c := g.countMatches()
-	if c == 0 {
-		g.Scores[name] += 1
-		return ResultCorrect, g.Scores[name], g.dealMore()
-	}
-	log.Printf("wrong nomatch claim, %d matches, these cards %+v", c, cards)
-	g.ClaimedNoMatch = true
-	g.Scores[name] -= 1
-	return ResultWrong, g.Scores[name], makeRevealCount(c)
-}
-
-func (g *Game) dealMore() Update {
-	var cs []PlacedCard
-	x := g.columns()
-	for y := 0; y < 3; y++ {
-		p := Position{X: x, Y: y}
-		if len(g.Deck) > 0 {
-			c := g.Deck[0]
-			g.Deck = g.Deck[1:]
-			g.Cards[p] = c
-			cs = append(cs, PlacedCard{p, c})
-		}
-	}
-	g.ClaimedNoMatch = false
-	return ChangeDeal(cs)
-}
-
-func (g *Game) columns() int {
-	m := -1
-	for p := range g.Cards {
-		if p.X+1 > m {
-			m = p.X + 1
-		}
-	}
-	if dc := g.DefaultColumns; m < dc {
-		return dc
-	}
-	return m
-}
-
-func (g *Game) empty(p Position) bool {
-	_, ok := g.Cards[p]
-	return !ok
-}
-
-func (g *Game) compact() Update {
-	cols := g.columns()
-	up := func(p Position) Position {
-		if p.Y == 2 {
-			return Position{X: p.X + 1, Y: 0}
-		} else {
-			return Position{X: p.X, Y: p.Y + 1}
-		}
-	}
-	down := func(p Position) Position {
-		if p.Y == 0 {
-			return Position{X: p.X - 1, Y: 2}
-		} else {
-			return Position{X: p.X, Y: p.Y - 1}
-		}
-	}
-	l := Position{X: 0, Y: 0}
-	h := Position{X: cols - 1, Y: 2}
-	var moves []Move
-	for {
-		for ; !g.empty(l) && l.X < cols; l = up(l) {
-		}
-		for ; g.empty(h) && h.X > l.X && h.X >= g.DefaultColumns; h = down(h) {
-		}
-		if g.empty(l) && !g.empty(h) && h.X > l.X && h.X >= g.DefaultColumns {
-			g.Cards[l] = g.Cards[h]
-			delete(g.Cards, h)
-			moves = append(moves, Move{
-				From: h,
-				To:   l,
-			})
-		} else {
-			break
-		}
-	}
-	if len(moves) == 0 {
+// matchUpdate and its siblings turn the engine's changes into
+// updates, where no change means no update.
+func matchUpdate(ps []triples.Position) Update {
+	if len(ps) == 0 {
 		return nil
 	}
-	return ChangeMove(moves)
+	return ChangeMatch(ps)
 }
 
-func (g *Game) deal() Update {
-	var cs []PlacedCard
-	for x := 0; x < g.DefaultColumns; x++ {
-		for y := 0; y < 3; y++ {
-			p := Position{X: x, Y: y}
-			if _, ok := g.Cards[p]; !ok {
-				if len(g.Deck) > 0 {
-					c := g.Deck[0]
-					g.Deck = g.Deck[1:]
-					g.Cards[p] = c
-					cs = append(cs, PlacedCard{p, c})
-				}
-			}
-		}
-	}
+func dealUpdate(cs []triples.PlacedCard) Update {
 	if len(cs) == 0 {
 		return nil
 	}
-	log.Printf("dealing %d cards", len(cs))
-	g.ClaimedNoMatch = false
 	return ChangeDeal(cs)
 }
 
-func makeRevealCount(count int) Update {
-	return nil
+func moveUpdate(ms []triples.Move) Update {
+	if len(ms) == 0 {
+		return nil
+	}
+	return ChangeMove(ms)
 }
 
 func (r *Room) loop() {
@@ -413,8 +232,25 @@ func (r *Room) loop() {
 	var (
 		clientId int
 		clients  = map[int]*client{}
-		g        *Game
+		keys     = map[string]string{}
+		owners   = map[string]string{} // the key each name first joined with
+		teams    = map[string]string{} // by player, if the room plays in teams
+		chat     []ChatLine
+		// Players may say at most talkBurst chat messages
+		// and reactions in any talkWindow.
+		talk     = newRateLimit(talkBurst, talkWindow)
+		claims   = newRateLimit(conf.Rooms.ClaimBurst, conf.Rooms.ClaimWindow.Duration)
+		bots     []string
+		stopBots = map[string]chan struct{}{}
+		g        *triples.Game
+		t        *tournament
+		next     <-chan time.Time // the next round of a tournament
+		kicked   = map[string]bool{}
+		pings    = map[string]int{} // by player, in milliseconds
+		seq      int                // the number of the last update sent to everyone
+		recent   []Sequenced        // the last few of them
 	)
+	resumeBuffer := conf.Rooms.ResumeBuffer
 	present := func() map[string]struct{} {
 		p := map[string]struct{}{}
 		for _, c := range clients {
@@ -422,18 +258,130 @@ func (r *Room) loop() {
 		}
 		return p
 	}
+	// unicast sends to one client, numbering the update
+	// with the place in the stream it belongs to
+	unicast := func(c *client, u Update) {
+		if c.numbered {
+			u = Sequenced{Seq: seq, Update: u}
+		}
+		c.updates <- u
+	}
 	sendAfter := func(u Update, after time.Duration) {
 		if u == nil {
 			return
 		}
 		time.Sleep(after)
+		seq++
+		if resumeBuffer > 0 {
+			recent = append(recent, Sequenced{Seq: seq, Update: u})
+			if len(recent) > resumeBuffer {
+				recent = recent[len(recent)-resumeBuffer:]
+			}
+		}
 		for _, c := range clients {
-			c.updates <- u
+			unicast(c, u)
+		}
+	}
+	// missed returns the updates a client missed since resume,
+	// or false if they aren't all there any more
+	missed := func(s session) ([]Sequenced, bool) {
+		if s.stream != r.stream || s.resume <= 0 || s.resume > seq {
+			return nil, false
+		}
+		if s.resume == seq {
+			return nil, true
+		}
+		if len(recent) == 0 || recent[0].Seq > s.resume+1 {
+			return nil, false
 		}
+		return recent[s.resume+1-recent[0].Seq:], true
 	}
 	send := func(u Update) {
 		sendAfter(u, 0)
 	}
+	rated := func() map[string]int {
+		if r.ratings == nil {
+			return nil
+		}
+		return r.ratings.lookup(keys)
+	}
+	// snapshot is a Full update with the chat history
+	snapshot := func(g *triples.Game, present map[string]struct{}) Full {
+		f := makeFull(g, r.game, present, teams, rated())
+		f.Chat = append([]ChatLine{}, chat...)
+		for p, s := range f.Players {
+			if _, ok := present[p]; ok {
+				s.Ping = pings[p]
+				f.Players[p] = s
+			}
+		}
+		return f
+	}
+	playing := func() bool {
+		return g != nil && !g.GameOver()
+	}
+	start := func(typ triples.Type) {
+		g = triples.NewGame(typ)
+		g.DefaultColumns = conf.Rooms.columns(typ)
+		ps := present()
+		for p := range ps {
+			g.Add(p)
+			if _, ok := teams[p]; !ok && len(teams) > 0 {
+				teams[p] = assignTeam(teams, ps)
+			}
+		}
+		send(snapshot(g, ps))
+		sendAfter(dealUpdate(g.Deal()), conf.Rooms.AnimationDelay.Duration)
+	}
+	full := func() bool {
+		return r.max > 0 && len(present()) >= r.max
+	}
+	// turnAway lets a client know why it can't come in
+	turnAway := func(cl *client, why string) {
+		cl.updates <- EventNotice{Text: why}
+		close(cl.updates)
+		clientId++
+	}
+	inspect := func() RoomState {
+		s := RoomState{
+			Room:    r.room,
+			Playing: playing(),
+			Bots:    append([]string{}, bots...),
+			Teams:   map[string]string{},
+			Chat:    append([]ChatLine{}, chat...),
+		}
+		for id, cl := range clients {
+			s.Clients = append(s.Clients, ClientState{
+				ID:    id,
+				Name:  cl.Name(),
+				Team:  teams[cl.Name()],
+				Keyed: cl.key != "",
+				RTT:   int(cl.rtt / time.Millisecond),
+			})
+		}
+		sort.Slice(s.Clients, func(i, j int) bool { return s.Clients[i].ID < s.Clients[j].ID })
+		for p, t := range teams {
+			s.Teams[p] = t
+		}
+		if g != nil {
+			s.DeckSize = g.DeckSize()
+			s.Scores = map[string]int{}
+			for p, sc := range g.Scores {
+				s.Scores[p] = sc
+			}
+			for p, c := range g.Cards {
+				s.Cards = append(s.Cards, triples.PlacedCard{Position: p, Card: c})
+			}
+			sort.Slice(s.Cards, func(i, j int) bool {
+				return positionNumber(s.Cards[i].Position) < positionNumber(s.Cards[j].Position)
+			})
+		}
+		if t != nil {
+			u := t.update()
+			s.Tournament = &u
+		}
+		return s
+	}
 	for {
 		select {
 		case <-r.quit:
@@ -444,80 +392,276 @@ func (r *Room) loop() {
 		case cl := <-r.connects:
 			cl.sendId <- clientId
 			_, alreadyThere := present()[cl.Name()]
+			if kicked[cl.Name()] {
+				log.Printf("turning away kicked player %s", cl.Name())
+				turnAway(cl, kickedNotice)
+				break
+			}
+			if !alreadyThere && full() {
+				log.Printf("room full, turning away %s", cl.Name())
+				turnAway(cl, roomFullNotice)
+				break
+			}
+			// Scores and results go by name, so a name
+			// stays with whoever took it first.
+			if key, ok := owners[cl.Name()]; ok && key != cl.key {
+				log.Printf("name taken, turning away %s", cl.Name())
+				turnAway(cl, nameTakenNotice)
+				break
+			}
+			owners[cl.Name()] = cl.key
 			clients[clientId] = cl
+			cl.updates <- r.hello(clientId)
 			clientId++
+			if cl.key != "" {
+				keys[cl.Name()] = cl.key
+			}
+			if team := cleanTeam(cl.team); team != "" {
+				// no changing sides during a game
+				if _, ok := teams[cl.Name()]; !ok || !playing() {
+					teams[cl.Name()] = team
+				}
+			}
 			if g != nil {
-				g.add(cl.Name())
+				g.Add(cl.Name())
+			}
+			if us, ok := missed(cl.session); ok {
+				log.Printf("%s resumes after %d missed updates", cl.Name(), len(us))
+				for _, u := range us {
+					cl.updates <- u
+				}
+			} else {
+				unicast(cl, snapshot(g, present()))
+				if t != nil && t.played > 0 {
+					unicast(cl, t.update())
+				}
 			}
-			cl.updates <- makeFull(g, r.game, present())
 			if !alreadyThere {
-				send(EventOnline{Name: cl.Name(), Present: true})
+				send(EventOnline{Name: cl.Name(), Present: true, Team: teams[cl.Name()]})
+			}
+		case c := <-r.fulls:
+			c <- snapshot(g, present())
+		case c := <-r.inspects:
+			c <- inspect()
+		case k := <-r.kicks:
+			n := 0
+			for id, cl := range clients {
+				if cl.Name() != k.name {
+					continue
+				}
+				cl.updates <- EventNotice{Text: kickedNotice}
+				close(cl.updates)
+				delete(clients, id)
+				n++
 			}
+			if stop, ok := stopBots[k.name]; ok {
+				close(stop)
+				delete(stopBots, k.name)
+				for i, b := range bots {
+					if b == k.name {
+						bots = append(bots[:i], bots[i+1:]...)
+						break
+					}
+				}
+			}
+			if n > 0 {
+				log.Printf("kicked %s", k.name)
+				kicked[k.name] = true
+				send(EventOnline{Name: k.name, Present: false, Team: teams[k.name]})
+			}
+			k.done <- n
+		case text := <-r.notices:
+			send(EventNotice{Text: text})
+		case <-next:
+			next = nil
+			log.Printf("starting round %d of %d", t.played+1, len(t.types))
+			start(t.next())
 		case c := <-r.cmds:
 			cl := clients[c.clientId]
+			if cl == nil {
+				log.Printf("command from departed client %d: %+v", c.clientId, c.command)
+				break
+			}
+			if err := validate(c.command, g); err != nil {
+				log.Printf("invalid command from %s: %s", cl.Name(), err)
+				unicast(cl, EventInvalid{Command: commandTag(c.command), Error: err.Error()})
+				break
+			}
 			switch cmd := c.command.(type) {
 			case CmdDisconnect:
 				log.Printf("removing client %d", c.clientId)
 				close(cl.updates)
 				delete(clients, c.clientId)
 				if _, ok := present()[cl.Name()]; !ok {
-					send(EventOnline{Name: cl.Name(), Present: false})
+					delete(pings, cl.Name())
+					send(EventOnline{Name: cl.Name(), Present: false, Team: teams[cl.Name()]})
+				}
+			case CmdPong:
+				cl.rtt = cmd.RTT
+				ms := int(cmd.RTT / time.Millisecond)
+				if ms < 1 {
+					ms = 1
+				}
+				pings[cl.Name()] = ms
+				// Round-trip times are only for show, so they
+				// stay out of the resume buffer and only go to
+				// those who show them.
+				for _, c := range clients {
+					if c.pings {
+						c.updates <- EventPing{Name: cl.Name(), Ping: ms}
+					}
+				}
+			case CmdAddBot:
+				level := cmd.Level
+				if level == "" {
+					level = defaultDifficulty
+				}
+				d, ok := difficulties[level]
+				if !ok {
+					log.Printf("unknown bot difficulty: %s", level)
+					break
+				}
+				if len(bots) >= conf.Rooms.MaxBots || full() {
+					log.Printf("too many bots, not adding another")
+					break
+				}
+				taken := present()
+				for _, b := range bots {
+					taken[b] = struct{}{}
+				}
+				name := botName(level, taken)
+				log.Printf("adding bot %s on behalf of %s", name, cl.Name())
+				stop := make(chan struct{})
+				bots = append(bots, name)
+				stopBots[name] = stop
+				go runBotPlayer(r, name, d, stop)
+			case CmdRemoveBot:
+				name := cmd.Name
+				if name == "" && len(bots) > 0 {
+					name = bots[len(bots)-1]
+				}
+				stop, ok := stopBots[name]
+				if !ok {
+					log.Printf("no bot to remove: %q", name)
+					break
+				}
+				log.Printf("removing bot %s on behalf of %s", name, cl.Name())
+				close(stop)
+				delete(stopBots, name)
+				for i, b := range bots {
+					if b == name {
+						bots = append(bots[:i], bots[i+1:]...)
+						break
+					}
 				}
 			case CmdStart:
-				if g != nil && !g.gameover() {
+				if g != nil && !g.GameOver() {
 					log.Printf("game in progress, ignoring start message")
 					break
 				}
+				if t != nil {
+					// don't wait for the break to end
+					next = nil
+					log.Printf("starting round %d of %d on behalf of %s", t.played+1, len(t.types), cl.Name())
+					start(t.next())
+					break
+				}
+				if cmd.Rounds > 1 {
+					log.Printf("starting tournament of %d rounds on behalf of %s", cmd.Rounds, cl.Name())
+					t = newTournament(r.game, cmd.Rounds, cmd.Alternate)
+					start(t.next())
+					break
+				}
 				log.Printf("starting game on behalf of %s", cl.Name())
-				g = newGame(r.game)
-				ps := present()
-				for p := range ps {
-					g.add(p)
+				start(r.game)
+			case CmdTeam:
+				if playing() {
+					log.Printf("game in progress, %s can't change teams", cl.Name())
+					break
 				}
-				send(makeFull(g, r.game, ps))
-				sendAfter(g.deal(), 250*time.Millisecond)
+				if team := cleanTeam(cmd.Team); team != "" {
+					teams[cl.Name()] = team
+				} else {
+					delete(teams, cl.Name())
+				}
+				send(snapshot(g, present()))
+			case CmdChat:
+				text, ok := cleanChat(cmd.Text)
+				if !ok {
+					break
+				}
+				if !talk.allow(cl.Name(), time.Now()) {
+					log.Printf("%s is talking too much", cl.Name())
+					break
+				}
+				line := ChatLine{Name: cl.Name(), Text: text}
+				chat = append(chat, line)
+				if len(chat) > chatHistory {
+					chat = chat[len(chat)-chatHistory:]
+				}
+				send(EventChat(line))
+			case CmdReact:
+				if !talk.allow(cl.Name(), time.Now()) {
+					log.Printf("%s is talking too much", cl.Name())
+					break
+				}
+				send(EventReact{Name: cl.Name(), Reaction: cmd.Reaction})
 			case CmdClaim:
-				if g == nil || g.gameover() {
+				if g == nil || g.GameOver() {
 					log.Printf("out of game claim: %+v", cmd)
 					break
 				}
+				if !claims.allow(cl.Name(), time.Now()) {
+					log.Printf("%s is claiming too much", cl.Name())
+					unicast(cl, EventInvalid{Command: "claim", Error: "too many claims, slow down"})
+					break
+				}
 				switch cmd.Type {
 				case ClaimMatch:
-					res, score, up := g.claimMatch(cl.Name(), cmd.Cards)
-					send(up)
-					send(EventClaimed{
-						Name:   cl.Name(),
-						Type:   cmd.Type,
-						Result: res,
-						Score:  score,
-					})
+					res, score, ps := g.ClaimMatch(cl.Name(), cmd.Cards)
+					send(matchUpdate(ps))
+					send(claimed(cl.Name(), cmd.Type, res, score, teams, g.Scores))
 					gameover := func() {
 						log.Printf("game over")
-						h := &Game{
+						h := &triples.Game{
+							Type:   g.Type,
 							Scores: g.Scores,
-							Cards:  map[Position]int{},
+							Cards:  map[triples.Position]triples.Card{},
 						}
 						g = nil
-						sendAfter(makeFull(h, r.game, present()), 250*time.Millisecond)
+						sendAfter(snapshot(h, present()), conf.Rooms.AnimationDelay.Duration)
+						if t == nil {
+							r.report(keys, h.Scores)
+							return
+						}
+						t.finishRound(h.Scores)
+						send(t.update())
+						if !t.done() {
+							next = time.After(roundBreak)
+							return
+						}
+						log.Printf("tournament over")
+						send(EventPodium{Standings: t.standings()})
+						r.report(keys, t.totals)
+						t = nil
 					}
-					if g.gameover() {
+					if g.GameOver() {
 						gameover()
 					} else {
-						sendAfter(g.compact(), 250*time.Millisecond)
-						sendAfter(g.deal(), 250*time.Millisecond)
-						if g.gameover() {
+						sendAfter(moveUpdate(g.Compact()), conf.Rooms.AnimationDelay.Duration)
+						sendAfter(dealUpdate(g.Deal()), conf.Rooms.AnimationDelay.Duration)
+						if g.GameOver() {
 							gameover()
 						}
 					}
 				case ClaimNoMatch:
-					res, score, upd := g.claimNomatch(cl.Name(), cmd.Cards)
-					send(upd)
-					send(EventClaimed{
-						Name:   cl.Name(),
-						Type:   cmd.Type,
-						Result: res,
-						Score:  score,
-					})
+					res, score, dealt := g.ClaimNoMatch(cl.Name(), cmd.Cards)
+					if res == triples.Correct {
+						send(ChangeDeal(dealt))
+					} else if res == triples.Wrong {
+						log.Printf("wrong nomatch claim, %d matches, these cards %+v", g.CountMatches(), cmd.Cards)
+					}
+					send(claimed(cl.Name(), cmd.Type, res, score, teams, g.Scores))
 				default:
 					log.Printf("unknown claim type: %s", cmd.Type)
 				}
@@ -528,14 +672,12 @@ func (r *Room) loop() {
 	}
 }
 
-type Position struct {
-	X int
-	Y int
-}
-
 type Status struct {
-	Present bool
-	Score   int
+	Present bool   `json:"present"`
+	Score   int    `json:"score"`
+	Team    string `json:"team"`
+	Rating  int    `json:"rating"` // 0 for unrated players
+	Ping    int    `json:"ping"`   // round-trip milliseconds, 0 if unknown
 }
 
 type ClaimType string
@@ -545,14 +687,6 @@ const (
 	ClaimNoMatch ClaimType = "nomatch"
 )
 
-type ResultType string
-
-const (
-	ResultCorrect = "correct"
-	ResultWrong   = "wrong"
-	ResultLate    = "late"
-)
-
 type Command interface {
 	isCommand()
 }
@@ -560,67 +694,161 @@ type Command interface {
 type CmdDisconnect struct{}        //synthetic
 func (c CmdDisconnect) isCommand() {}
 
-type CmdStart struct{}
+// CmdPong reports a client's round-trip time.
+type CmdPong struct { //synthetic
+	RTT time.Duration
+}
+
+func (c CmdPong) isCommand() {}
+
+// CmdStart starts a game, or a tournament if more than one round
+// is asked for. Tournament rounds may alternate between triples and
+// quadruples.
+type CmdStart struct {
+	Rounds    int  `json:"rounds"`
+	Alternate bool `json:"alternate"`
+}
 
 func (c CmdStart) isCommand() {}
 
 type CmdClaim struct {
-	Type  ClaimType
-	Cards []int
+	Type  ClaimType      `json:"type"`
+	Cards []triples.Card `json:"cards"`
 }
 
 func (c CmdClaim) isCommand() {}
 
+// CmdAddBot adds a computer player of the given difficulty.
+type CmdAddBot struct {
+	Level string `json:"level"`
+}
+
+func (c CmdAddBot) isCommand() {}
+
+// CmdRemoveBot removes the named computer player,
+// or the last one added if no name is given.
+type CmdRemoveBot struct {
+	Name string `json:"name"`
+}
+
+func (c CmdRemoveBot) isCommand() {}
+
 type EventOnline struct {
-	Present bool
-	Name    string
+	Present bool   `json:"present"`
+	Name    string `json:"name"`
+	Team    string `json:"team"`
 }
 
 func (u EventOnline) isUpdate()   {}
 func (u EventOnline) tag() string { return "eventOnline" }
 
+// CmdTeam joins a team, or leaves it if the name is empty.
+type CmdTeam struct {
+	Team string `json:"team"`
+}
+
+func (c CmdTeam) isCommand() {}
+
+// CmdChat says something to the room.
+type CmdChat struct {
+	Text string `json:"text"`
+}
+
+func (c CmdChat) isCommand() {}
+
+// CmdReact sends one of the quick reactions.
+type CmdReact struct {
+	Reaction string `json:"reaction"`
+}
+
+func (c CmdReact) isCommand() {}
+
+type EventChat ChatLine
+
+func (u EventChat) isUpdate()   {}
+func (u EventChat) tag() string { return "eventChat" }
+
+// EventPing is a player's latest round-trip time.
+type EventPing struct {
+	Name string `json:"name"`
+	Ping int    `json:"ping"` // in milliseconds
+}
+
+func (u EventPing) isUpdate()   {}
+func (u EventPing) tag() string { return "eventPing" }
+
+// EventNotice is a message from the server operators.
+type EventNotice struct {
+	Text string `json:"text"`
+}
+
+func (u EventNotice) isUpdate()   {}
+func (u EventNotice) tag() string { return "eventNotice" }
+
+type EventReact struct {
+	Name     string `json:"name"`
+	Reaction string `json:"reaction"`
+}
+
+func (u EventReact) isUpdate()   {}
+func (u EventReact) tag() string { return "eventReact" }
+
+// EventClaimed reports a claim. In rooms that play in teams,
+// it also has the player's team and its new score.
 type EventClaimed struct {
-	Name   string
-	Type   ClaimType
-	Result ResultType
-	Score  int
+	Name      string         `json:"name"`
+	Type      ClaimType      `json:"type"`
+	Result    triples.Result `json:"result"`
+	Score     int            `json:"score"`
+	Team      string         `json:"team"`
+	TeamScore int            `json:"teamScore"`
 }
 
 func (u EventClaimed) isUpdate()   {}
 func (u EventClaimed) tag() string { return "eventClaimed" }
 
-type ChangeMatch []Position
+type ChangeMatch []triples.Position
 
 func (u ChangeMatch) isUpdate()   {}
 func (u ChangeMatch) tag() string { return "changeMatch" }
 
-type PlacedCard struct {
-	Position Position
-	Card     int
-}
-
-type ChangeDeal []PlacedCard
+type ChangeDeal []triples.PlacedCard
 
 func (u ChangeDeal) isUpdate()   {}
 func (u ChangeDeal) tag() string { return "changeDeal" }
 
-type Move struct {
-	From Position
-	To   Position
-}
-
-type ChangeMove []Move
+type ChangeMove []triples.Move
 
 func (u ChangeMove) isUpdate()   {}
 func (u ChangeMove) tag() string { return "changeMove" }
 
+// EventStandings are the standings of a tournament after a round.
+type EventStandings struct {
+	Round     int        `json:"round"`
+	Rounds    int        `json:"rounds"`
+	Standings []Standing `json:"standings"`
+}
+
+func (u EventStandings) isUpdate()   {}
+func (u EventStandings) tag() string { return "eventStandings" }
+
+// EventPodium are the final standings of a tournament.
+type EventPodium struct {
+	Standings []Standing `json:"standings"`
+}
+
+func (u EventPodium) isUpdate()   {}
+func (u EventPodium) tag() string { return "eventPodium" }
+
 type Full struct {
-	Cols      int
-	Rows      int
-	MatchSize int
-	DeckSize  int
-	Cards     map[Position]int
-	Players   map[string]Status
+	Cols      int                               `json:"cols"`
+	Rows      int                               `json:"rows"`
+	MatchSize int                               `json:"matchSize"`
+	DeckSize  int                               `json:"deckSize"`
+	Cards     map[triples.Position]triples.Card `json:"cards"`
+	Players   map[string]Status                 `json:"players"`
+	Teams     map[string]int                    `json:"teams"` // team scores
+	Chat      []ChatLine                        `json:"chat"`  // the latest chat messages
 }
 
 func (u Full) isUpdate()   {}
@@ -631,10 +859,24 @@ type Update interface {
 	tag() string
 }
 
-func makeFull(g *Game, typ int, present map[string]struct{}) Update {
+func claimed(name string, typ ClaimType, res triples.Result, score int, teams map[string]string, scores map[string]int) EventClaimed {
+	e := EventClaimed{
+		Name:   name,
+		Type:   typ,
+		Result: res,
+		Score:  score,
+	}
+	if team, ok := teams[name]; ok {
+		e.Team = team
+		e.TeamScore = teamScores(teams, scores)[team]
+	}
+	return e
+}
+
+func makeFull(g *triples.Game, typ triples.Type, present map[string]struct{}, teams map[string]string, ratings map[string]int) Full {
 	var (
 		deckSize = 0
-		cards    = map[Position]int{}
+		cards    = map[triples.Position]triples.Card{}
 		players  = map[string]Status{}
 	)
 	if g == nil {
@@ -642,21 +884,37 @@ func makeFull(g *Game, typ int, present map[string]struct{}) Update {
 			players[p] = Status{Present: true}
 		}
 	} else {
-		deckSize = g.deckSize()
+		deckSize = g.DeckSize()
 		for p, s := range g.Scores {
 			_, ok := present[p]
 			players[p] = Status{Present: ok, Score: s}
 		}
-		cards = g.Cards
+		for p, c := range g.Cards {
+			cards[p] = c
+		}
 		typ = g.Type
 	}
+	var scores map[string]int
+	if g != nil {
+		scores = g.Scores
+	}
+	ts := map[string]int{}
+	for p, s := range players {
+		if team, ok := teams[p]; ok {
+			s.Team = team
+			ts[team] += scores[p]
+		}
+		s.Rating = ratings[p]
+		players[p] = s
+	}
 	return Full{
-		Cols:      defaultColumns[typ],
+		Cols:      conf.Rooms.columns(typ),
 		Rows:      3,
-		MatchSize: matchSize[typ],
+		MatchSize: typ.MatchSize(),
 		DeckSize:  deckSize,
 		Cards:     cards,
 		Players:   players,
+		Teams:     ts,
 	}
 }
 
@@ -665,49 +923,130 @@ func (r *Room) close() {
 	close(r.quit)
 }
 
-func (r *Room) connect(name string) (<-chan Update, chan<- *cmd, <-chan int) {
+// report passes the final scores of players who joined
+// with a key on to the result handler and the ratings.
+func (r *Room) report(keys map[string]string, scores map[string]int) {
+	if len(keys) == 0 {
+		return
+	}
+	ks := map[string]string{}
+	for name, key := range keys {
+		if _, ok := scores[name]; ok {
+			ks[name] = key
+		}
+	}
+	ss := map[string]int{}
+	for name, score := range scores {
+		ss[name] = score
+	}
+	if r.results != nil {
+		go r.results(ks, ss)
+	}
+	if r.ratings != nil {
+		go r.ratings.results(ks, ss)
+	}
+}
+
+// full returns a snapshot of the room's game,
+// or false if the room has been closed.
+func (r *Room) full() (Full, bool) {
+	c := make(chan Update, 1)
+	select {
+	case r.fulls <- c:
+		return (<-c).(Full), true
+	case <-r.quit:
+		return Full{}, false
+	}
+}
+
+func (r *Room) connect(name, key, team string) (<-chan Update, chan<- *cmd, <-chan int) {
+	return r.connectSession(name, key, team, session{})
+}
+
+func (r *Room) connectSession(name, key, team string, s session) (<-chan Update, chan<- *cmd, <-chan int) {
 	log.Printf("player connecting: %s", name)
 	updates := make(chan Update)
-	sendId := make(chan int)
-	r.connects <- &client{
+	sendId := make(chan int, 1)
+	select {
+	case r.connects <- &client{
 		name:    name,
+		key:     key,
+		team:    team,
 		updates: updates,
 		sendId:  sendId,
+		session: s,
+	}:
+	case <-r.quit:
+		// the room closed under us
+		sendId <- -1
+		close(updates)
 	}
 	return updates, r.cmds, sendId
 }
 
+// commandTypes are the commands clients can send, by tag.
+var commandTypes = map[string]Command{
+	"claim":     CmdClaim{},
+	"start":     CmdStart{},
+	"addBot":    CmdAddBot{},
+	"removeBot": CmdRemoveBot{},
+	"team":      CmdTeam{},
+	"chat":      CmdChat{},
+	"react":     CmdReact{},
+}
+
 var commandTagMap edn.TagMap
 
 func init() {
-	if err := commandTagMap.AddTagStruct("triples/claim", CmdClaim{}); err != nil {
-		panic(err)
-	}
-	if err := commandTagMap.AddTagStruct("triples/start", CmdStart{}); err != nil {
-		panic(err)
+	for tag, c := range commandTypes {
+		if err := commandTagMap.AddTagStruct("triples/"+tag, c); err != nil {
+			panic(err)
+		}
 	}
 }
 
-func (r *Room) Serve(name string, w http.ResponseWriter, req *http.Request) {
+func (r *Room) Serve(name, key, team string, w http.ResponseWriter, req *http.Request) {
 	conn, err := upgrader.Upgrade(w, req, nil)
 	if err != nil {
 		log.Printf("websocket upgrade: %s", err)
 		return
 	}
 	defer conn.Close()
+	if max := conf.Rooms.MaxMessageSize; max > 0 {
+		conn.SetReadLimit(int64(max))
+	}
+	codec := pickCodec(conn, req)
+	proto, _ := parseProtocol(req)
+
+	updates, cmds, getId := r.connectSession(name, key, team, proto.session(req))
 
-	updates, cmds, getId := r.connect(name)
+	keepalive := conf.Rooms.PingInterval.Duration > 0
+	readDeadline := func() {
+		if keepalive {
+			conn.SetReadDeadline(time.Now().Add(conf.Rooms.PingInterval.Duration + conf.Rooms.PongTimeout.Duration))
+		}
+	}
+	readDeadline()
 
 	go func() {
 		clientId := <-getId
-		defer func() {
-			cmds <- &cmd{
-				clientId: clientId,
-				command:  CmdDisconnect{},
+		command := func(c Command) {
+			select {
+			case cmds <- &cmd{clientId: clientId, command: c}:
+			case <-r.quit:
 			}
-		}()
+		}
+		defer command(CmdDisconnect{})
+		// pings carry the time they were sent
+		conn.SetPongHandler(func(data string) error {
+			readDeadline()
+			if sent, err := strconv.ParseInt(data, 10, 64); err == nil {
+				command(CmdPong{RTT: time.Since(time.Unix(0, sent))})
+			}
+			return nil
+		})
 		for {
-			t, r, err := conn.NextReader()
+			t, msg, err := conn.NextReader()
 			if err != nil {
 				log.Printf("conn err: %s", err)
 				return
@@ -715,37 +1054,62 @@ func (r *Room) Serve(name string, w http.ResponseWriter, req *http.Request) {
 			switch t {
 			case websocket.TextMessage:
 				log.Printf("receiving message from %s", name)
-				d := edn.NewDecoder(r)
-				d.UseTagMap(&commandTagMap)
-				var c Command
-				if err := d.Decode(&c); err != nil {
+				c, err := codec.decode(msg)
+				if err != nil {
 					log.Printf("decode err: %s", err)
 					return
 				}
-				cmds <- &cmd{
-					clientId: clientId,
-					command:  c,
-				}
+				command(c)
 			default:
 				log.Printf("ignoring message type: %d", t)
 			}
 		}
 	}()
 
-	for u := range updates {
-		log.Printf("sending message to %s", name)
-		if err := writeUpdate(conn, u); err != nil {
-			log.Print(err)
+	var ping <-chan time.Time
+	if keepalive {
+		t := time.NewTicker(conf.Rooms.PingInterval.Duration)
+		defer t.Stop()
+		ping = t.C
+	}
+	// After an error, the connection is closed so the reader notices,
+	// but we keep taking updates until the room lets go of us.
+	dead := false
+	fail := func(err error) {
+		log.Printf("connection to %s failed: %s", name, err)
+		dead = true
+		ping = nil
+		conn.Close()
+	}
+	for {
+		select {
+		case u, ok := <-updates:
+			if !ok {
+				log.Print("left room")
+				return
+			}
+			if dead || !proto.wants(u) {
+				break
+			}
+			log.Printf("sending message to %s", name)
+			if err := writeUpdate(conn, codec, u); err != nil {
+				fail(err)
+			}
+		case <-ping:
+			data := []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+			if err := conn.WriteControl(websocket.PingMessage, data, time.Now().Add(conf.Rooms.WriteTimeout.Duration)); err != nil {
+				fail(err)
+			}
 		}
 	}
-	log.Print("left room")
 }
 
-func writeUpdate(conn *websocket.Conn, u Update) error {
+func writeUpdate(conn *websocket.Conn, c codec, u Update) error {
+	conn.SetWriteDeadline(time.Now().Add(conf.Rooms.WriteTimeout.Duration))
 	w, err := conn.NextWriter(websocket.TextMessage)
 	if err != nil {
 		return err
 	}
 	defer w.Close()
-	return edn.NewEncoder(w).Encode(edn.Tag{Tagname: "triples/" + u.tag(), Value: u})
+	return c.encode(w, u)
 }
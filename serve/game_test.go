@@ -1,153 +1,121 @@
 package main
 
 import (
+	"bytes"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/robx/triples/serve/triples"
+	"gopkg.in/edn.v1"
 )
 
-func TestDealMatches(t *testing.T) {
-	g := newGame(GameTriples)
-	if have, want := len(g.Deck), 81; have != want {
-		t.Errorf("have %v, want %v", have, want)
-	}
-	if have, want := len(g.listCards()), 0; have != want {
-		t.Errorf("have %v, want %v", have, want)
-	}
-	if have, want := g.columns(), 4; have != want {
-		t.Errorf("have %v, want %v", have, want)
-	}
-	g.deal()
-	if have, want := len(g.Deck), 69; have != want {
-		t.Errorf("have %v, want %v", have, want)
-	}
-	if have, want := len(g.listCards()), 12; have != want {
-		t.Errorf("have %v, want %v", have, want)
-	}
-	if have, want := g.columns(), 4; have != want {
-		t.Errorf("have %v, want %v", have, want)
-	}
-	g.dealMore()
-	if have, want := len(g.Deck), 66; have != want {
-		t.Errorf("have %v, want %v", have, want)
-	}
-	if have, want := len(g.listCards()), 15; have != want {
-		t.Errorf("have %v, want %v", have, want)
-	}
-	if have, want := g.columns(), 5; have != want {
-		t.Errorf("have %v, want %v", have, want)
-	}
-	g.dealMore()
-	if have, want := len(g.Deck), 63; have != want {
-		t.Errorf("have %v, want %v", have, want)
-	}
-	if have, want := len(g.listCards()), 18; have != want {
-		t.Errorf("have %v, want %v", have, want)
-	}
-	if have, want := g.columns(), 6; have != want {
-		t.Errorf("have %v, want %v", have, want)
-	}
-	for i := 0; i < 27-6; i++ {
-		g.dealMore()
-	}
-	if have, want := len(g.Deck), 0; have != want {
-		t.Errorf("have %v, want %v", have, want)
-	}
-	if have, want := len(g.listCards()), 81; have != want {
-		t.Errorf("have %v, want %v", have, want)
-	}
-	if have, want := g.columns(), 27; have != want {
-		t.Errorf("have %v, want %v", have, want)
-	}
-	if have, want := g.countMatches(), 1080; have != want {
-		t.Errorf("have %v, want %v", have, want)
+func TestDecodeCommands(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want Command
+	}{
+		{`#triples/claim {:type "match" :cards [3 17 80]}`, CmdClaim{Type: ClaimMatch, Cards: []triples.Card{3, 17, 80}}},
+		{`#triples/start {}`, CmdStart{}},
+		{`#triples/start {:rounds 5 :alternate true}`, CmdStart{Rounds: 5, Alternate: true}},
+		{`#triples/chat {:text "hi"}`, CmdChat{Text: "hi"}},
+		{`#triples/react {:reaction "👍"}`, CmdReact{Reaction: "👍"}},
+		{`#triples/addBot {:level "hard"}`, CmdAddBot{Level: "hard"}},
+		{`#triples/removeBot {}`, CmdRemoveBot{}},
+	} {
+		d := edn.NewDecoder(strings.NewReader(tc.in))
+		d.UseTagMap(&commandTagMap)
+		var c Command
+		if err := d.Decode(&c); err != nil {
+			t.Fatalf("%s: %v", tc.in, err)
+		}
+		if !reflect.DeepEqual(c, tc.want) {
+			t.Errorf("have %#v, want %#v", c, tc.want)
+		}
 	}
 }
 
-func TestCompact(t *testing.T) {
-	g := newGame(GameTriples)
-	g.deal()
-	g.dealMore()
-	g.dealMore()
-	if have, want := len(g.listCards()), 18; have != want {
-		t.Errorf("have %v, want %v", have, want)
+func TestEncodeCards(t *testing.T) {
+	var buf bytes.Buffer
+	u := ChangeDeal{{Position: triples.Position{X: 1, Y: 2}, Card: 42}}
+	if err := edn.NewEncoder(&buf).Encode(u); err != nil {
+		t.Fatal(err)
 	}
-	{
-		var (
-			p1 = Position{X: 0, Y: 0}
-			p2 = Position{X: 3, Y: 2}
-			p3 = Position{X: 5, Y: 1}
-			q1 = Position{X: 5, Y: 2}
-			q2 = Position{X: 5, Y: 0}
-			v1 = g.Cards[q1]
-			v2 = g.Cards[q2]
-		)
-		delete(g.Cards, p1)
-		delete(g.Cards, p2)
-		delete(g.Cards, p3)
-		g.compact()
-		if have, want := g.Cards[p1], v1; have != want {
-			t.Errorf("p1: have %v, want %v", have, want)
-		}
-		if have, want := g.Cards[p2], v2; have != want {
-			t.Errorf("p2: have %v, want %v", have, want)
-		}
-		if have, want := g.Cards[p3], 0; have != want {
-			t.Errorf("p3: have %v, want %v", have, want)
-		}
-		if have, want := g.Cards[q1], 0; have != want {
-			t.Errorf("q1: have %v, want %v", have, want)
-		}
-		if have, want := g.Cards[q2], 0; have != want {
-			t.Errorf("q2: have %v, want %v", have, want)
-		}
+	if have, want := strings.TrimSpace(buf.String()), `[{:position{:x 1 :y 2}:card 42}]`; have != want {
+		t.Errorf("have %s, want %s", have, want)
 	}
-	{
-		var (
-			p1 = Position{X: 0, Y: 0}
-			p2 = Position{X: 4, Y: 0}
-			p3 = Position{X: 4, Y: 2}
-			q1 = Position{X: 4, Y: 1}
-			v1 = g.Cards[q1]
-		)
-		delete(g.Cards, p1)
-		delete(g.Cards, p2)
-		delete(g.Cards, p3)
-		g.compact()
-		if have, want := g.Cards[p1], v1; have != want {
-			t.Errorf("p1: have %v, want %v", have, want)
-		}
-		if have, want := g.Cards[p2], 0; have != want {
-			t.Errorf("p2: have %v, want %v", have, want)
+}
+
+// joinRoom connects to r like a client, returning the updates and
+// a way to send commands. The updates are buffered, so the room
+// never waits for the test, and closed when the room lets go.
+func joinRoom(r *Room, name, team string) (<-chan Update, func(Command)) {
+	return joinSession(r, name, "", team, session{})
+}
+
+func joinSession(r *Room, name, key, team string, s session) (<-chan Update, func(Command)) {
+	us, cmds, getId := r.connectSession(name, key, team, s)
+	id := <-getId
+	updates := make(chan Update, 1000)
+	go func() {
+		for u := range us {
+			updates <- u
 		}
-		if have, want := g.Cards[p3], 0; have != want {
-			t.Errorf("p3: have %v, want %v", have, want)
+		close(updates)
+	}()
+	return updates, func(c Command) { cmds <- &cmd{clientId: id, command: c} }
+}
+
+// waitEmpty waits until every connection to rooms has been let go,
+// so tests that change conf don't race with the handlers of others.
+func waitEmpty(rooms *Rooms) {
+	for {
+		n := 0
+		rooms.mu.Lock()
+		for _, r := range rooms.rooms {
+			n += r.count
 		}
-		if have, want := g.Cards[q1], 0; have != want {
-			t.Errorf("q1: have %v, want %v", have, want)
+		rooms.mu.Unlock()
+		if n == 0 {
+			return
 		}
+		time.Sleep(time.Millisecond)
 	}
-	{
-		var (
-			p1 = Position{X: 2, Y: 1}
-			p2 = Position{X: 4, Y: 0}
-			p3 = Position{X: 4, Y: 1}
-			q1 = Position{X: 4, Y: 2}
-			v1 = g.Cards[q1]
-		)
-		delete(g.Cards, p1)
-		delete(g.Cards, p2)
-		delete(g.Cards, p3)
-		g.compact()
-		if have, want := g.Cards[p1], v1; have != want {
-			t.Errorf("p1: have %v, want %v", have, want)
-		}
-		if have, want := g.Cards[p2], 0; have != want {
-			t.Errorf("p2: have %v, want %v", have, want)
-		}
-		if have, want := g.Cards[p3], 0; have != want {
-			t.Errorf("p3: have %v, want %v", have, want)
-		}
-		if have, want := g.Cards[q1], 0; have != want {
-			t.Errorf("q1: have %v, want %v", have, want)
+}
+
+// waitFor reads updates until f is happy with one.
+func waitFor(t *testing.T, updates <-chan Update, f func(Update) bool) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case u := <-updates:
+			if f(u) {
+				return
+			}
+		case <-timeout:
+			t.Fatal("timed out")
 		}
 	}
 }
+
+func TestNameTaken(t *testing.T) {
+	r := newRoom("triplesmulti", "names", nil, nil)
+	defer r.close()
+	isFull := func(u Update) bool {
+		_, ok := u.(Full)
+		return ok
+	}
+	isTaken := func(u Update) bool {
+		n, ok := u.(EventNotice)
+		return ok && n.Text == nameTakenNotice
+	}
+	ann, _ := joinSession(r, "Ann", "ann's key", "", session{})
+	waitFor(t, ann, isFull)
+	other, _ := joinSession(r, "Ann", "another key", "", session{})
+	waitFor(t, other, isTaken)
+	anon, _ := joinRoom(r, "Ann", "")
+	waitFor(t, anon, isTaken)
+	again, _ := joinSession(r, "Ann", "ann's key", "", session{})
+	waitFor(t, again, isFull)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestKeepalive(t *testing.T) {
+	defer func(c RoomConfig) { conf.Rooms = c }(conf.Rooms)
+	conf.Rooms.PingInterval = Duration{20 * time.Millisecond}
+	conf.Rooms.PongTimeout = Duration{50 * time.Millisecond}
+
+	rooms := newRooms(nil, nil)
+	defer waitEmpty(rooms)
+	r := httprouter.New()
+	r.GET("/api/join", multiHandler(rooms))
+	s := httptest.NewServer(r)
+	defer s.Close()
+	base := "ws" + strings.TrimPrefix(s.URL, "http") + "/api/join?game=triplesmulti&room=keepalive&format=json"
+
+	alice, _, err := websocket.DefaultDialer.Dial(base+"&name=alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer alice.Close()
+	read := func(want func(typ string, value map[string]interface{}) bool) {
+		alice.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			var u struct {
+				Type  string
+				Value map[string]interface{}
+			}
+			if err := alice.ReadJSON(&u); err != nil {
+				t.Fatal(err)
+			}
+			if want(u.Type, u.Value) {
+				return
+			}
+		}
+	}
+	// alice answers pings while reading, so she gets a round-trip time
+	read(func(typ string, v map[string]interface{}) bool {
+		return typ == "eventPing" && v["name"] == "alice" && v["ping"].(float64) > 0
+	})
+
+	// bob never reads, so he never answers pings either
+	bob, _, err := websocket.DefaultDialer.Dial(base+"&name=bob", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bob.Close()
+	read(func(typ string, v map[string]interface{}) bool {
+		return typ == "eventOnline" && v["name"] == "bob" && v["present"] == true
+	})
+	read(func(typ string, v map[string]interface{}) bool {
+		return typ == "eventOnline" && v["name"] == "bob" && v["present"] == false
+	})
+}
+
+func TestPingsOutOfStream(t *testing.T) {
+	r := newRoom("triplesmulti", "pings", nil, nil)
+	defer r.close()
+	alice, command := joinSession(r, "alice", "", "", session{numbered: true, pings: true})
+	bob, _ := joinSession(r, "bob", "", "", session{numbered: true})
+
+	command(CmdPong{RTT: 5 * time.Millisecond})
+	command(CmdChat{Text: "hi"})
+	waitFor(t, alice, func(u Update) bool {
+		p, ok := u.(EventPing)
+		return ok && p.Name == "alice" && p.Ping == 5
+	})
+	last := 0
+	waitFor(t, bob, func(u Update) bool {
+		if _, ok := u.(Hello); ok {
+			return false
+		}
+		s, ok := u.(Sequenced)
+		if !ok {
+			t.Fatalf("unnumbered %T", u)
+		}
+		if _, ok := s.Update.(EventPing); ok {
+			t.Fatal("bob got a ping")
+		}
+		if _, ok := s.Update.(EventChat); ok {
+			if s.Seq != last+1 {
+				t.Errorf("chat is update %d after %d", s.Seq, last)
+			}
+			return true
+		}
+		last = s.Seq
+		return false
+	})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// admit counts a connection from an address, or returns false
+// if that address has too many already.
+func (rs *Rooms) admit(ip string) bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if max := conf.Rooms.MaxConnsPerIP; max > 0 && rs.ips[ip] >= max {
+		return false
+	}
+	rs.ips[ip] += 1
+	return true
+}
+
+func (rs *Rooms) leave(ip string) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.ips[ip] -= 1
+	if rs.ips[ip] <= 0 {
+		delete(rs.ips, ip)
+	}
+}
+
+// clientIP is the address a request comes from. Behind a reverse
+// proxy on the same machine, that's the last X-Forwarded-For entry.
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return host
+	}
+	fwd := req.Header.Values("X-Forwarded-For")
+	if len(fwd) == 0 {
+		return host
+	}
+	hops := strings.Split(fwd[len(fwd)-1], ",")
+	if last := strings.TrimSpace(hops[len(hops)-1]); last != "" {
+		return last
+	}
+	return host
+}
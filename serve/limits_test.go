@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/julienschmidt/httprouter"
+	"github.com/robx/triples/serve/triples"
+)
+
+func TestClientIP(t *testing.T) {
+	for _, c := range []struct {
+		remote, forwarded, want string
+	}{
+		{"203.0.113.7:5000", "", "203.0.113.7"},
+		{"203.0.113.7:5000", "198.51.100.1", "203.0.113.7"},
+		{"127.0.0.1:5000", "", "127.0.0.1"},
+		{"127.0.0.1:5000", "198.51.100.1", "198.51.100.1"},
+		{"[::1]:5000", "10.0.0.1, 198.51.100.1", "198.51.100.1"},
+	} {
+		req := httptest.NewRequest("GET", "/api/join", nil)
+		req.RemoteAddr = c.remote
+		if c.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+		if have := clientIP(req); have != c.want {
+			t.Errorf("%s %s: have %s, want %s", c.remote, c.forwarded, have, c.want)
+		}
+	}
+}
+
+func TestConnectionLimits(t *testing.T) {
+	defer func(c RoomConfig) { conf.Rooms = c }(conf.Rooms)
+	conf.Rooms.MaxConnsPerIP = 1
+	conf.Rooms.MaxMessageSize = 100
+
+	rooms := newRooms(nil, nil)
+	defer waitEmpty(rooms)
+	r := httprouter.New()
+	r.GET("/api/join", multiHandler(rooms))
+	s := httptest.NewServer(r)
+	defer s.Close()
+	base := "ws" + strings.TrimPrefix(s.URL, "http") + "/api/join?game=triplesmulti&room=limits&format=json"
+
+	conn, _, err := websocket.DefaultDialer.Dial(base+"&name=ann", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, resp, err := websocket.DefaultDialer.Dial(base+"&name=bob", nil); err == nil || resp.StatusCode != 429 {
+		t.Errorf("second connection: have %v", err)
+	}
+
+	// commands that are too long end the connection
+	long := `{"type": "chat", "value": {"text": "` + strings.Repeat("a", 200) + `"}}`
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(long)); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+}
+
+func TestClaimLimit(t *testing.T) {
+	defer func(c RoomConfig) { conf.Rooms = c }(conf.Rooms)
+	conf.Rooms.ClaimBurst = 2
+
+	r := newRoom("triplesmulti", "claims", nil, nil)
+	defer r.close()
+	alice, send := joinRoom(r, "alice", "")
+	send(CmdStart{})
+	var cards []triples.Card
+	waitFor(t, alice, func(u Update) bool {
+		d, ok := u.(ChangeDeal)
+		for _, c := range d {
+			cards = append(cards, c.Card)
+		}
+		return ok
+	})
+	// right, wrong or late, they all count
+	for i := 0; i < 3; i++ {
+		send(CmdClaim{Type: ClaimMatch, Cards: cards[:3]})
+	}
+	waitFor(t, alice, func(u Update) bool {
+		e, ok := u.(EventInvalid)
+		return ok && strings.Contains(e.Error, "too many claims")
+	})
+}
@@ -1,23 +1,18 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 )
 
-var (
-	listen   = flag.String("listen", ":8080", "http listen")
-	static   = flag.String("static", "./static/", "points to static/")
-	debugbot = flag.Bool("debugbot", false, "debug logs for the Telegram bot")
-	baseURL  = flag.String("base", "https://arp.vllmrt.net/triples", "http base URL")
-	bot      = flag.Bool("bot", true, "run the telegram bot")
-)
-
 var (
 	games = []string{
 		"triples",
@@ -33,28 +28,100 @@ var (
 
 func main() {
 	flag.Parse()
+	if err := configure(); err != nil {
+		log.Fatal(err)
+	}
+	if *printConfig {
+		if err := conf.print(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	var (
+		score    ScoreHandler
+		results  ResultHandler
+		identify Identifier
+		q        *ScoreQueue
+		rs       *Ratings
+	)
+	if conf.Bot {
+		var err error
+		q, err = openScoreQueue(conf.Queue)
+		if err != nil {
+			log.Fatal(err)
+		}
+		score, results, identify = startBot(conf.TelegramToken, q)
+		// only Telegram users can be told apart
+		rs, err = openRatings(conf.Ratings, identify)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	var score ScoreHandler
-	if *bot {
-		score = startBot(os.Getenv("TELEGRAM_TOKEN"))
+	ps, err := openPuzzles(conf.Puzzles)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rooms := newRooms(results, rs)
+	prefix := conf.prefix()
+	assets := embeddedAssets(prefix)
+	if conf.Static != "" {
+		assets = diskAssets(conf.Static, prefix)
+	}
+	m := mux(assets, score, rooms, q, ps, rs)
+	// the admin API is off without a token
+	if conf.AdminToken == "" {
+		log.Printf("no admin token, admin API disabled")
+	} else if conf.Admin == "" {
+		admin(m, rooms, conf.AdminToken)
+		log.Printf("admin API at %s/api/admin/", prefix)
+	} else {
+		a := httprouter.New()
+		admin(a, rooms, conf.AdminToken)
+		go func() {
+			log.Printf("admin API listening on %s...\n", conf.Admin)
+			log.Fatal(http.ListenAndServe(conf.Admin, a))
+		}()
 	}
 
-	log.Printf("listening on %s...\n", *listen)
-	log.Fatal(http.ListenAndServe(*listen, mux(*static, score)))
+	log.Printf("listening on %s%s/...\n", conf.Listen, prefix)
+	srv := &http.Server{
+		Addr:        conf.Listen,
+		Handler:     mount(prefix, cors(m)),
+		ConnContext: withConn,
+	}
+	log.Fatal(srv.ListenAndServe())
 }
 
-func mux(static string, score ScoreHandler) *httprouter.Router {
+func mux(static *Assets, score ScoreHandler, rooms *Rooms, queue *ScoreQueue, puzzles *Puzzles, ratings *Ratings) *httprouter.Router {
 	r := httprouter.New()
-	if static != "" {
-		r.GET("/", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-			http.ServeFile(w, r, static+"index.html")
-		})
-		r.ServeFiles("/static/*filepath", http.Dir(static))
+	if static != nil {
+		r.GET("/", indexHandler(static))
+		r.GET("/static/*filepath", staticHandler(static))
 	}
 	if score != nil {
 		r.GET("/api/win", winHandler(score))
 	}
-	r.GET("/api/join", multiHandler(newRooms()))
+	if queue != nil {
+		r.GET("/api/queue", queueHandler(queue))
+	}
+	r.GET("/api/join", multiHandler(rooms))
+	r.POST("/api/rooms", createRoomHandler(rooms))
+	r.GET("/api/events", eventsHandler(rooms))
+	r.POST("/api/command", commandHandler(rooms))
+	r.GET("/api/board.svg", boardHandler(rooms, "svg"))
+	r.GET("/api/board.png", boardHandler(rooms, "png"))
+	if puzzles != nil {
+		r.GET("/api/puzzle/today", puzzleTodayHandler(puzzles))
+		r.POST("/api/puzzle/solve", puzzleSolveHandler(puzzles))
+		r.GET("/api/puzzle/leaderboard", puzzleLeaderboardHandler(puzzles))
+	}
+	if ratings != nil {
+		r.GET("/api/ratings", ratingsHandler(ratings))
+		r.GET("/api/ratings/:id", playerRatingHandler(ratings))
+	}
 	return r
 }
 
@@ -70,31 +137,95 @@ func winHandler(handleScore ScoreHandler) httprouter.Handle {
 			http.Error(w, "missing/bad parameter `score`", http.StatusBadRequest)
 			return
 		}
-		if err := handleScore(key, s); err != nil {
+		if err := handleScore(key, s); errors.Is(err, errBadKey) {
 			log.Print(err)
 			http.Error(w, "bad key", http.StatusBadRequest)
+		} else if err != nil {
+			log.Print(err)
+			http.Error(w, "could not store score", http.StatusServiceUnavailable)
 		}
 
 	}
 }
 
+// joinParams checks the parameters for joining a room,
+// answering the request if they won't do.
+func joinParams(w http.ResponseWriter, r *http.Request) (game, room, name string, ok bool) {
+	room = r.FormValue("room")
+	if room == "" {
+		http.Error(w, "missing parameter `room`", http.StatusBadRequest)
+		return
+	}
+	game = r.FormValue("game")
+	if game == "" {
+		http.Error(w, "missing parameter `game`", http.StatusBadRequest)
+		return
+	}
+	if !isMultiGame(game) {
+		http.Error(w, "no such game", http.StatusNotFound)
+		return
+	}
+	name = r.FormValue("name")
+	if name == "" {
+		http.Error(w, "missing parameter `name`", http.StatusBadRequest)
+		return
+	}
+	return game, room, name, true
+}
+
 func multiHandler(rooms *Rooms) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-		room := r.FormValue("room")
-		if room == "" {
-			http.Error(w, "missing parameter `room`", http.StatusBadRequest)
+		game, room, name, ok := joinParams(w, r)
+		if !ok {
+			return
+		}
+		if !knownFormat(r.FormValue("format")) {
+			http.Error(w, "bad parameter `format`", http.StatusBadRequest)
 			return
 		}
-		game := r.FormValue("game")
-		if game == "" {
-			http.Error(w, "missing parameter `game`", http.StatusBadRequest)
+		if _, err := parseProtocol(r); err != nil {
+			refuse(w, r, closeIncompatible, err.Error())
 			return
 		}
-		name := r.FormValue("name")
-		if name == "" {
-			http.Error(w, "missing parameter `name`", http.StatusBadRequest)
+		if err := rooms.allow(game, room, r.FormValue("password"), r.FormValue("invite"), clientIP(r), time.Now()); err != nil {
+			refuse(w, r, closeForbidden, err.Error())
 			return
 		}
-		rooms.Serve(game, room, name, w, r)
+		rooms.Serve(game, room, name, r.FormValue("key"), r.FormValue("team"), w, r)
+	}
+}
+
+// mount serves h under a path prefix like /triples, so there's
+// no need to rewrite paths in a reverse proxy.
+func mount(prefix string, h http.Handler) http.Handler {
+	if prefix == "" {
+		return h
+	}
+	m := http.NewServeMux()
+	m.Handle(prefix+"/", http.StripPrefix(prefix, h))
+	m.HandleFunc(prefix, func(w http.ResponseWriter, r *http.Request) {
+		u := prefix + "/"
+		if r.URL.RawQuery != "" {
+			u += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, u, http.StatusMovedPermanently)
+	})
+	return m
+}
+
+// isMultiGame tells whether game is an enabled multiplayer game.
+func isMultiGame(game string) bool {
+	for _, g := range multigames {
+		if g == game {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Print(err)
 	}
 }
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMount(t *testing.T) {
+	h := mount("/triples", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	for _, c := range []struct {
+		path     string
+		code     int
+		body     string
+		location string
+	}{
+		{"/triples/", http.StatusOK, "/", ""},
+		{"/triples/api/join?room=x", http.StatusOK, "/api/join", ""},
+		{"/triples?game=triples", http.StatusMovedPermanently, "", "/triples/?game=triples"},
+		{"/api/join", http.StatusNotFound, "", ""},
+		{"/triplesmulti", http.StatusNotFound, "", ""},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", c.path, nil))
+		if w.Code != c.code {
+			t.Errorf("%s: have %d, want %d", c.path, w.Code, c.code)
+		}
+		if c.body != "" && w.Body.String() != c.body {
+			t.Errorf("%s: have %s, want %s", c.path, w.Body.String(), c.body)
+		}
+		if have := w.Header().Get("Location"); have != c.location {
+			t.Errorf("%s: redirect to %s, want %s", c.path, have, c.location)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// allowedOrigin tells whether pages from a browser origin like
+// https://cdn.example.com may use the API: those from the host
+// they ask, from the base URL, or listed in conf.Origins.
+func allowedOrigin(origin string, req *http.Request) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, req.Host) {
+		return true
+	}
+	if b, err := url.Parse(conf.Base); err == nil && b.Host != "" &&
+		strings.EqualFold(b.Scheme, u.Scheme) && strings.EqualFold(b.Host, u.Host) {
+		return true
+	}
+	for _, o := range conf.Origins {
+		if o == "*" || strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkOrigin is the websocket upgrader's origin check. Requests
+// without an Origin don't come from browsers and are let through.
+func checkOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	return origin == "" || allowedOrigin(origin, req)
+}
+
+// cors lets pages from the allowed origins call the API,
+// except for the admin API.
+func cors(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || strings.HasPrefix(r.URL.Path, "/api/admin/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		if !allowedOrigin(origin, r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+			w.Header().Set("Access-Control-Max-Age", "86400")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
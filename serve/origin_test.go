@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAllowedOrigin(t *testing.T) {
+	defer func(base string, origins []string) { conf.Base, conf.Origins = base, origins }(conf.Base, conf.Origins)
+	conf.Base = "https://example.com/triples"
+	conf.Origins = []string{"https://cdn.example.net/"}
+
+	for _, c := range []struct {
+		origin string
+		want   bool
+	}{
+		{"http://api.example.org", true}, // same host
+		{"https://example.com", true},
+		{"http://example.com", false},
+		{"https://cdn.example.net", true},
+		{"https://evil.example.net", false},
+		{"null", false},
+	} {
+		req := httptest.NewRequest("GET", "http://api.example.org/api/join", nil)
+		if have := allowedOrigin(c.origin, req); have != c.want {
+			t.Errorf("%s: have %v, want %v", c.origin, have, c.want)
+		}
+	}
+
+	conf.Origins = []string{"*"}
+	if !allowedOrigin("https://anywhere.example", httptest.NewRequest("GET", "/", nil)) {
+		t.Errorf("* doesn't allow everything")
+	}
+}
+
+func TestCORS(t *testing.T) {
+	defer func(origins []string) { conf.Origins = origins }(conf.Origins)
+	conf.Origins = []string{"https://cdn.example.net"}
+	h := cors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	do := func(method, path, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Origin", origin)
+		if method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", "POST")
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("OPTIONS", "/api/command", "https://cdn.example.net")
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "https://cdn.example.net" {
+		t.Errorf("preflight: have %d %v", w.Code, w.Header())
+	}
+	w = do("GET", "/api/win", "https://cdn.example.net")
+	if w.Body.String() != "ok" || w.Header().Get("Access-Control-Allow-Origin") != "https://cdn.example.net" {
+		t.Errorf("allowed: have %s %v", w.Body, w.Header())
+	}
+	if w := do("GET", "/api/win", "https://evil.example.net"); w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("not allowed: have %v", w.Header())
+	}
+	if w := do("GET", "/api/admin/rooms", "https://cdn.example.net"); w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("admin: have %v", w.Header())
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Rooms made with POST /api/rooms get an ID nobody can guess,
+// and may have a password and fewer seats than usual. Players
+// get in with the password, or with an invite that the room's
+// maker hands out, which works until it expires.
+
+const (
+	// maxReservations bounds the number of rooms made with
+	// POST /api/rooms, even if the number of rooms isn't
+	// limited otherwise.
+	maxReservations = 100000
+	// Each address may make createBurst rooms in any createWindow,
+	// and try guessBurst passwords in any guessWindow.
+	createBurst  = 10
+	createWindow = time.Hour
+	guessBurst   = 10
+	guessWindow  = time.Minute
+	// Room passwords only matter while the room is in use,
+	// and are checked often, so they get a cheap hash.
+	passwordCost = bcrypt.MinCost
+	// Password rooms that nobody used in reservationIdle
+	// are forgotten.
+	reservationIdle = 7 * 24 * time.Hour
+)
+
+var (
+	errTooManyRooms   = errors.New("too many rooms")
+	errNotInvited     = errors.New("this room needs a password or an invite")
+	errTooManyGuesses = errors.New("too many passwords tried, wait a minute")
+)
+
+// reservation is what we know of a room made with POST /api/rooms.
+// It is kept while the room is open, and at least until the invites
+// expire. With a password, it is kept until the room has been
+// unused for reservationIdle, since anyone could use the room's ID
+// once it's gone.
+type reservation struct {
+	password   []byte // bcrypt hash, nil for none
+	maxPlayers int
+	expires    time.Time
+	used       time.Time // when the room was last open
+}
+
+// invite is an invite to a room, sealed with the server's key.
+type invite struct {
+	Game    string `json:"g"`
+	Room    string `json:"r"`
+	Expires int64  `json:"e"`
+}
+
+// create reserves a new room, returning its ID and an invite.
+func (rs *Rooms) create(game, password string, maxPlayers int, now time.Time) (string, string, error) {
+	// hashing takes a while, so we check for room first
+	rs.mu.Lock()
+	full := rs.reservedFull(now)
+	rs.mu.Unlock()
+	if full {
+		return "", "", errTooManyRooms
+	}
+	res := &reservation{
+		maxPlayers: maxPlayers,
+		expires:    now.Add(conf.Rooms.InviteExpiry.Duration),
+		used:       now,
+	}
+	if password != "" {
+		h, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+		if err != nil {
+			return "", "", err
+		}
+		res.password = h
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.reservedFull(now) {
+		return "", "", errTooManyRooms
+	}
+	room := newToken()
+	rs.reserved[[2]string{game, room}] = res
+	inv := seal(invite{Game: game, Room: room, Expires: res.expires.Unix()}, rs.inviteKey)
+	return room, inv, nil
+}
+
+// reservedFull drops reservations that are no longer needed, and
+// tells whether there's still no room for another. The caller
+// holds rs.mu.
+func (rs *Rooms) reservedFull(now time.Time) bool {
+	for k, r := range rs.reserved {
+		if rs.rooms[k] != nil || now.Before(r.expires) {
+			continue
+		}
+		if r.password == nil || now.Sub(r.used) > reservationIdle {
+			delete(rs.reserved, k)
+		}
+	}
+	max := maxReservations
+	if conf.Rooms.MaxRooms > 0 && conf.Rooms.MaxRooms < max {
+		max = conf.Rooms.MaxRooms
+	}
+	return len(rs.reserved) >= max
+}
+
+// allow checks that a player from an address may join a room:
+// anyone may join rooms that weren't made with a password.
+func (rs *Rooms) allow(game, room, password, inv, ip string, now time.Time) error {
+	rs.mu.Lock()
+	res := rs.reserved[[2]string{game, room}]
+	rs.mu.Unlock()
+	if res == nil || res.password == nil {
+		return nil
+	}
+	var i invite
+	if inv != "" && unseal(inv, rs.inviteKey, &i) == nil &&
+		i.Game == game && i.Room == room && now.Unix() < i.Expires {
+		return nil
+	}
+	if password == "" {
+		return errNotInvited
+	}
+	rs.mu.Lock()
+	ok := rs.guesses.allow(ip, now)
+	rs.mu.Unlock()
+	if !ok {
+		return errTooManyGuesses
+	}
+	if bcrypt.CompareHashAndPassword(res.password, []byte(password)) == nil {
+		return nil
+	}
+	return errNotInvited
+}
+
+// refuseHTTP answers a request that allow refused.
+func refuseHTTP(w http.ResponseWriter, err error) {
+	status := http.StatusForbidden
+	if errors.Is(err, errTooManyGuesses) {
+		status = http.StatusTooManyRequests
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// maxPlayers is the seat limit of a room, 0 for none.
+// The caller holds rs.mu.
+func (rs *Rooms) maxPlayers(game, room string) int {
+	if res := rs.reserved[[2]string{game, room}]; res != nil && res.maxPlayers > 0 {
+		return res.maxPlayers
+	}
+	return conf.Rooms.MaxPlayers
+}
+
+// createRoomHandler makes a room, taking the game, and optionally
+// a password and fewer players than the server allows.
+func createRoomHandler(rooms *Rooms) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		ip := clientIP(r)
+		if !rooms.admit(ip) {
+			http.Error(w, "too many connections", http.StatusTooManyRequests)
+			return
+		}
+		defer rooms.leave(ip)
+		rooms.mu.Lock()
+		ok := rooms.creates.allow(ip, time.Now())
+		rooms.mu.Unlock()
+		if !ok {
+			http.Error(w, "too many new rooms", http.StatusTooManyRequests)
+			return
+		}
+		game := r.FormValue("game")
+		if game == "" {
+			game = "triplesmulti"
+		}
+		if !isMultiGame(game) {
+			http.Error(w, "no such game", http.StatusNotFound)
+			return
+		}
+		max := 0
+		if s := r.FormValue("maxPlayers"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 || (conf.Rooms.MaxPlayers > 0 && n > conf.Rooms.MaxPlayers) {
+				http.Error(w, "bad parameter `maxPlayers`", http.StatusBadRequest)
+				return
+			}
+			max = n
+		}
+		password := r.FormValue("password")
+		room, inv, err := rooms.create(game, password, max, time.Now())
+		if errors.Is(err, errTooManyRooms) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			log.Print(err)
+			http.Error(w, "could not create room", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("created room %s %s", game, room)
+		writeJSON(w, struct {
+			Game       string `json:"game"`
+			Room       string `json:"room"`
+			Invite     string `json:"invite"`
+			Protected  bool   `json:"protected"`
+			MaxPlayers int    `json:"maxPlayers,omitempty"`
+		}{game, room, inv, password != "", max})
+	}
+}
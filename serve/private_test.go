@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestPrivateRooms(t *testing.T) {
+	rooms := newRooms(nil, nil)
+	defer waitEmpty(rooms)
+	r := httprouter.New()
+	r.GET("/api/join", multiHandler(rooms))
+	r.POST("/api/rooms", createRoomHandler(rooms))
+	s := httptest.NewServer(r)
+	defer s.Close()
+
+	if resp, err := http.PostForm(s.URL+"/api/rooms", url.Values{"game": {"chess"}}); err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown game: have %v %v", resp.StatusCode, err)
+	}
+	resp, err := http.PostForm(s.URL+"/api/rooms", url.Values{"game": {"triplesmulti"}, "password": {"sesame"}, "maxPlayers": {"2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var created struct {
+		Game, Room, Invite string
+		Protected          bool
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Game != "triplesmulti" || len(created.Room) < 16 || created.Invite == "" || !created.Protected {
+		t.Fatalf("have %+v", created)
+	}
+
+	now := time.Now()
+	other, _, _ := rooms.create("triplesmulti", "other", 0, now)
+	for _, c := range []struct {
+		room, password, invite string
+		now                    time.Time
+		ok                     bool
+	}{
+		{created.Room, "", "", now, false},
+		{created.Room, "wrong", "", now, false},
+		{created.Room, "sesame", "", now, true},
+		{created.Room, "", created.Invite, now, true},
+		{created.Room, "", created.Invite, now.Add(25 * time.Hour), false},
+		{created.Room, "", "forged", now, false},
+		{other, "", created.Invite, now, false},
+		{"public", "", "", now, true},
+	} {
+		err := rooms.allow("triplesmulti", c.room, c.password, c.invite, "192.0.2.1", c.now)
+		if (err == nil) != c.ok {
+			t.Errorf("%+v: have %v", c, err)
+		}
+	}
+	for i := 0; i < guessBurst; i++ {
+		rooms.allow("triplesmulti", created.Room, "guess", "", "192.0.2.2", now)
+	}
+	if err := rooms.allow("triplesmulti", created.Room, "sesame", "", "192.0.2.2", now); err != errTooManyGuesses {
+		t.Errorf("guessing: have %v", err)
+	}
+	if err := rooms.allow("triplesmulti", created.Room, "", created.Invite, "192.0.2.2", now); err != nil {
+		t.Errorf("invite after guessing: have %v", err)
+	}
+	// the password outlasts the invites
+	rooms.create("triplesmulti", "", 0, now.Add(25*time.Hour))
+	if err := rooms.allow("triplesmulti", created.Room, "", "", "192.0.2.1", now.Add(25*time.Hour)); err == nil {
+		t.Errorf("password gone after the invites expired")
+	}
+
+	base := "ws" + strings.TrimPrefix(s.URL, "http") + "/api/join?game=triplesmulti&format=json&room=" + created.Room
+	conn, _, err := websocket.DefaultDialer.Dial(base+"&name=eve", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := conn.ReadMessage(); err == nil {
+		t.Errorf("joined without the password")
+	} else if ce, ok := err.(*websocket.CloseError); !ok || ce.Code != closeForbidden {
+		t.Errorf("have %v", err)
+	}
+	conn.Close()
+
+	conn, _, err = websocket.DefaultDialer.Dial(base+"&name=ann&invite="+url.QueryEscape(created.Invite), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var hello struct{ Value Hello }
+	if err := conn.ReadJSON(&hello); err != nil {
+		t.Fatal(err)
+	}
+	if hello.Value.Settings.MaxPlayers != 2 {
+		t.Errorf("max players: have %d", hello.Value.Settings.MaxPlayers)
+	}
+}
+
+func TestCreateLimits(t *testing.T) {
+	defer func(c RoomConfig) { conf.Rooms = c }(conf.Rooms)
+	conf.Rooms.MaxRooms = 0
+	conf.Rooms.MaxConnsPerIP = 1
+	rooms := newRooms(nil, nil)
+	h := createRoomHandler(rooms)
+	post := func() int {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest("POST", "/api/rooms?game=triplesmulti", nil), nil)
+		return w.Code
+	}
+
+	ip := clientIP(httptest.NewRequest("POST", "/", nil))
+	rooms.admit(ip)
+	if have := post(); have != http.StatusTooManyRequests {
+		t.Errorf("busy address: have %d", have)
+	}
+	rooms.leave(ip)
+	if have := post(); have != http.StatusOK {
+		t.Errorf("have %d", have)
+	}
+
+	now := time.Now()
+	for len(rooms.reserved) < maxReservations {
+		rooms.reserved[[2]string{"triplesmulti", newToken()}] = &reservation{password: []byte("x"), expires: now, used: now}
+	}
+	if have := post(); have != http.StatusServiceUnavailable {
+		t.Errorf("too many rooms: have %d", have)
+	}
+	// unused password rooms go in time
+	if _, _, err := rooms.create("triplesmulti", "", 0, now.Add(reservationIdle+time.Minute)); err != nil {
+		t.Errorf("idle rooms kept: %s", err)
+	}
+
+	for i := 0; i < createBurst; i++ {
+		post()
+	}
+	if have := post(); have != http.StatusTooManyRequests {
+		t.Errorf("too many new rooms: have %d", have)
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/robx/triples/serve/triples"
+	"gopkg.in/edn.v1"
+)
+
+// A codec is a wire format of the room protocol. Rooms speak EDN
+// unless a client asks for JSON, either with the "triples.json"
+// websocket subprotocol or with format=json on the join URL.
+//
+// In JSON, commands and updates are objects with their type and
+// value, like
+//
+//	{"type": "claim", "value": {"type": "match", "cards": [3, 17, 80]}}
+//	{"type": "changeMatch", "value": [{"x": 0, "y": 1}]}
+type codec interface {
+	decode(r io.Reader) (Command, error)
+	encode(w io.Writer, u Update) error
+}
+
+const subprotocolPrefix = "triples."
+
+var codecs = map[string]codec{
+	"edn":  ednCodec{},
+	"json": jsonCodec{},
+}
+
+// pickCodec picks the codec for a connection: the subprotocol if
+// one was agreed on, then the format parameter, then EDN.
+func pickCodec(conn *websocket.Conn, req *http.Request) codec {
+	if p := conn.Subprotocol(); p != "" {
+		if c, ok := codecs[strings.TrimPrefix(p, subprotocolPrefix)]; ok {
+			return c
+		}
+	}
+	if c, ok := codecs[req.FormValue("format")]; ok {
+		return c
+	}
+	return ednCodec{}
+}
+
+func knownFormat(format string) bool {
+	_, ok := codecs[format]
+	return format == "" || ok
+}
+
+type ednCodec struct{}
+
+func (ednCodec) decode(r io.Reader) (Command, error) {
+	d := edn.NewDecoder(r)
+	d.UseTagMap(&commandTagMap)
+	var c Command
+	err := d.Decode(&c)
+	return c, err
+}
+
+func (ednCodec) encode(w io.Writer, u Update) error {
+	if s, ok := u.(Sequenced); ok {
+		return edn.NewEncoder(w).Encode(edn.Tag{Tagname: "triples/seq", Value: struct {
+			Seq    int     `edn:"seq"`
+			Update edn.Tag `edn:"update"`
+		}{s.Seq, edn.Tag{Tagname: "triples/" + s.tag(), Value: s.Update}}})
+	}
+	return edn.NewEncoder(w).Encode(edn.Tag{Tagname: "triples/" + u.tag(), Value: u})
+}
+
+type jsonCodec struct{}
+
+type jsonMessage struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+func (jsonCodec) decode(r io.Reader) (Command, error) {
+	var m jsonMessage
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, err
+	}
+	proto, ok := commandTypes[m.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown command type %q", m.Type)
+	}
+	v := reflect.New(reflect.TypeOf(proto))
+	if len(m.Value) > 0 {
+		if err := json.Unmarshal(m.Value, v.Interface()); err != nil {
+			return nil, err
+		}
+	}
+	return v.Elem().Interface().(Command), nil
+}
+
+func (jsonCodec) encode(w io.Writer, u Update) error {
+	if s, ok := u.(Sequenced); ok {
+		return json.NewEncoder(w).Encode(struct {
+			Type  string `json:"type"`
+			Seq   int    `json:"seq"`
+			Value Update `json:"value"`
+		}{s.tag(), s.Seq, s.Update})
+	}
+	return json.NewEncoder(w).Encode(struct {
+		Type  string `json:"type"`
+		Value Update `json:"value"`
+	}{u.tag(), u})
+}
+
+// MarshalJSON lists the cards, since JSON can't have
+// positions for keys.
+func (u Full) MarshalJSON() ([]byte, error) {
+	type full Full
+	var cards []triples.PlacedCard
+	for p, c := range u.Cards {
+		cards = append(cards, triples.PlacedCard{Position: p, Card: c})
+	}
+	sort.Slice(cards, func(i, j int) bool {
+		return positionNumber(cards[i].Position) < positionNumber(cards[j].Position)
+	})
+	return json.Marshal(struct {
+		full
+		Cards []triples.PlacedCard `json:"cards"`
+	}{full(u), cards})
+}
+
+// The protocol version goes up whenever commands or updates change
+// in ways older clients can't cope with. Clients say which version
+// they speak with protocol=N on the join URL, and which optional
+// updates they understand with caps=chat,teams,...; clients that
+// say nothing are taken to be version 1 and to understand everything.
+const (
+	protocolVersion    = 1
+	minProtocolVersion = 1
+
+	// closeIncompatible is the websocket close code
+	// for clients speaking an unsupported version.
+	closeIncompatible = 4000
+	// closeForbidden is for clients without the
+	// password or invite of a room.
+	closeForbidden = 4003
+)
+
+// capabilities are the optional parts of the protocol,
+// with the updates that belong to them. Clients with
+// "resume" get their updates numbered, see Sequenced.
+var capabilities = map[string][]string{
+	"chat":        {"eventChat", "eventReact"},
+	"errors":      {"eventInvalid"},
+	"resume":      nil,
+	"notices":     {"eventNotice"},
+	"pings":       {"eventPing"},
+	"tournaments": {"eventStandings", "eventPodium"},
+}
+
+// clientProtocol is what a client says it speaks.
+type clientProtocol struct {
+	version  int
+	skip     map[string]bool // update tags the client doesn't understand
+	numbered bool
+}
+
+// parseProtocol reads the protocol parameters of the join URL, the
+// client's half of the handshake; the hello update is the server's.
+func parseProtocol(req *http.Request) (clientProtocol, error) {
+	p := clientProtocol{version: 1}
+	if v := req.FormValue("protocol"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return p, fmt.Errorf("bad protocol version %q", v)
+		}
+		p.version = n
+	}
+	if p.version < minProtocolVersion || p.version > protocolVersion {
+		return p, fmt.Errorf("protocol version %d is not supported, this server speaks %d to %d; please reload",
+			p.version, minProtocolVersion, protocolVersion)
+	}
+	if _, ok := req.Form["caps"]; ok {
+		has := map[string]bool{}
+		for _, c := range strings.Split(req.FormValue("caps"), ",") {
+			has[strings.TrimSpace(c)] = true
+		}
+		p.numbered = has["resume"]
+		p.skip = map[string]bool{}
+		for c, tags := range capabilities {
+			for _, t := range tags {
+				p.skip[t] = !has[c]
+			}
+		}
+	}
+	return p, nil
+}
+
+func (p clientProtocol) wants(u Update) bool {
+	return !p.skip[u.tag()]
+}
+
+// session reads where a reconnecting client left off:
+// stream=...&resume=N, with N the last update it saw.
+func (p clientProtocol) session(req *http.Request) session {
+	s := session{numbered: p.numbered, pings: p.wants(EventPing{})}
+	if !p.numbered {
+		return s
+	}
+	s.stream = req.FormValue("stream")
+	s.resume, _ = strconv.Atoi(req.FormValue("resume"))
+	return s
+}
+
+// Sequenced is an update numbered with its place in the room's
+// stream of updates, for clients with the "resume" capability.
+// Updates for just one client carry the number of the last update
+// they include.
+type Sequenced struct {
+	Seq    int
+	Update Update
+}
+
+func (u Sequenced) isUpdate()   {}
+func (u Sequenced) tag() string { return u.Update.tag() }
+
+// refuse turns a websocket client away, with the reason in
+// the close message.
+func refuse(w http.ResponseWriter, req *http.Request, code int, reason string) {
+	conn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		log.Printf("websocket upgrade: %s", err)
+		return
+	}
+	defer conn.Close()
+	log.Printf("refusing client: %s", reason)
+	msg := websocket.FormatCloseMessage(code, reason)
+	if err := conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second)); err != nil {
+		log.Print(err)
+	}
+}
+
+// Hello is the first update on every connection.
+type Hello struct {
+	Version      int          `json:"version"`
+	Client       int          `json:"client"` // the ID of this connection
+	Commands     []string     `json:"commands"`
+	Capabilities []string     `json:"capabilities"`
+	Settings     RoomSettings `json:"settings"`
+	Stream       string       `json:"stream"` // for resuming
+}
+
+func (u Hello) isUpdate()   {}
+func (u Hello) tag() string { return "hello" }
+
+// RoomSettings describe a room. Zero limits mean no limit.
+type RoomSettings struct {
+	Game       string `json:"game"`
+	Room       string `json:"room"`
+	MatchSize  int    `json:"matchSize"`
+	Columns    int    `json:"columns"`
+	MaxPlayers int    `json:"maxPlayers"`
+	MaxBots    int    `json:"maxBots"`
+}
+
+func (r *Room) hello(clientId int) Hello {
+	h := Hello{
+		Version: protocolVersion,
+		Client:  clientId,
+		Stream:  r.stream,
+		Settings: RoomSettings{
+			Game:       r.game.String(),
+			Room:       r.room,
+			MatchSize:  r.game.MatchSize(),
+			Columns:    conf.Rooms.columns(r.game),
+			MaxPlayers: r.max,
+			MaxBots:    conf.Rooms.MaxBots,
+		},
+	}
+	for c := range commandTypes {
+		h.Commands = append(h.Commands, c)
+	}
+	for c := range capabilities {
+		h.Capabilities = append(h.Capabilities, c)
+	}
+	sort.Strings(h.Commands)
+	sort.Strings(h.Capabilities)
+	return h
+}
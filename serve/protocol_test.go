@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/julienschmidt/httprouter"
+	"github.com/robx/triples/serve/triples"
+)
+
+func TestJSONCodec(t *testing.T) {
+	for _, c := range []struct {
+		in   string
+		want Command
+	}{
+		{`{"type": "claim", "value": {"type": "match", "cards": [3, 17, 80]}}`, CmdClaim{Type: ClaimMatch, Cards: []triples.Card{3, 17, 80}}},
+		{`{"type": "start"}`, CmdStart{}},
+		{`{"type": "start", "value": {"rounds": 3}}`, CmdStart{Rounds: 3}},
+	} {
+		have, err := jsonCodec{}.decode(strings.NewReader(c.in))
+		if err != nil {
+			t.Errorf("%s: %s", c.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(have, c.want) {
+			t.Errorf("%s: have %#v, want %#v", c.in, have, c.want)
+		}
+	}
+	if _, err := (jsonCodec{}).decode(strings.NewReader(`{"type": "cheat"}`)); err == nil {
+		t.Errorf("unknown command decoded")
+	}
+
+	for _, c := range []struct {
+		u    Update
+		want string
+	}{
+		{ChangeDeal{{Position: triples.Position{X: 1, Y: 2}, Card: 42}}, `{"type":"changeDeal","value":[{"position":{"x":1,"y":2},"card":42}]}`},
+		{EventOnline{Name: "alice", Present: true}, `{"type":"eventOnline","value":{"present":true,"name":"alice","team":""}}`},
+		{
+			Full{Cols: 4, Rows: 3, Cards: map[triples.Position]triples.Card{{X: 1}: 7, {Y: 1}: 5}},
+			`{"type":"full","value":{"cols":4,"rows":3,"matchSize":0,"deckSize":0,"players":null,"teams":null,"chat":null,` +
+				`"cards":[{"position":{"x":0,"y":1},"card":5},{"position":{"x":1,"y":0},"card":7}]}}`,
+		},
+		{Sequenced{Seq: 7, Update: EventNotice{Text: "hi"}}, `{"type":"eventNotice","seq":7,"value":{"text":"hi"}}`},
+	} {
+		var buf bytes.Buffer
+		if err := (jsonCodec{}).encode(&buf, c.u); err != nil {
+			t.Fatal(err)
+		}
+		if have := strings.TrimSpace(buf.String()); have != c.want {
+			t.Errorf("have %s, want %s", have, c.want)
+		}
+	}
+}
+
+func TestProtocolNegotiation(t *testing.T) {
+	rooms := newRooms(nil, nil)
+	defer waitEmpty(rooms)
+	r := httprouter.New()
+	r.GET("/api/join", multiHandler(rooms))
+	s := httptest.NewServer(r)
+	defer s.Close()
+	base := "ws" + strings.TrimPrefix(s.URL, "http") + "/api/join?game=triplesmulti&room=proto"
+
+	for _, c := range []struct {
+		query       string
+		subprotocol string
+		prefix      string
+	}{
+		{"&name=edn", "", "#triples/hello"},
+		{"&name=param&format=json", "", `{"type":"hello"`},
+		{"&name=sub", "triples.json", `{"type":"hello"`},
+		{"&name=both&format=json", "triples.edn", "#triples/hello"},
+	} {
+		d := websocket.Dialer{}
+		if c.subprotocol != "" {
+			d.Subprotocols = []string{c.subprotocol}
+		}
+		conn, _, err := d.Dial(base+c.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, msg, err := conn.ReadMessage()
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(msg), c.prefix) {
+			t.Errorf("%s %s: have %.40s, want %s...", c.query, c.subprotocol, msg, c.prefix)
+		}
+	}
+
+	if _, resp, err := websocket.DefaultDialer.Dial(base+"&name=x&format=xml", nil); err == nil || resp.StatusCode != 400 {
+		t.Errorf("format=xml accepted")
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	rooms := newRooms(nil, nil)
+	defer waitEmpty(rooms)
+	r := httprouter.New()
+	r.GET("/api/join", multiHandler(rooms))
+	s := httptest.NewServer(r)
+	defer s.Close()
+	base := "ws" + strings.TrimPrefix(s.URL, "http") + "/api/join?game=quadruplesmulti&room=hello&format=json"
+
+	conn, _, err := websocket.DefaultDialer.Dial(base+"&name=old&protocol=0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = conn.ReadMessage()
+	conn.Close()
+	if ce, ok := err.(*websocket.CloseError); !ok || ce.Code != closeIncompatible || !strings.Contains(ce.Text, "version 0") {
+		t.Errorf("old client: have %v", err)
+	}
+
+	conn, _, err = websocket.DefaultDialer.Dial(base+"&name=new&protocol=1&caps=chat", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var hello struct {
+		Type  string
+		Value Hello
+	}
+	if err := conn.ReadJSON(&hello); err != nil {
+		t.Fatal(err)
+	}
+	h := hello.Value
+	if hello.Type != "hello" || h.Version != protocolVersion || h.Settings.Game != "quadruples" || h.Settings.MatchSize != 4 {
+		t.Errorf("hello: have %+v", hello)
+	}
+	if len(h.Commands) != len(commandTypes) {
+		t.Errorf("commands: have %v", h.Commands)
+	}
+
+	// no notices for clients that don't know them
+	rooms.find("quadruplesmulti", "hello").notice("hi")
+	if err := conn.WriteJSON(map[string]interface{}{"type": "chat", "value": map[string]string{"text": "hey"}}); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		var u struct{ Type string }
+		if err := conn.ReadJSON(&u); err != nil {
+			t.Fatal(err)
+		}
+		if u.Type == "eventNotice" {
+			t.Errorf("notice sent to a client without the capability")
+		}
+		if u.Type == "eventChat" {
+			break
+		}
+	}
+}
+
+func TestResume(t *testing.T) {
+	r := newRoom("triplesmulti", "resume", nil, nil)
+	defer r.close()
+	alice, _ := joinRoom(r, "alice", "")
+
+	join := func(s session) (<-chan Update, func()) {
+		us, cmds, getId := r.connectSession("bob", "", "", s)
+		id := <-getId
+		updates := make(chan Update, 1000)
+		go func() {
+			for u := range us {
+				updates <- u
+			}
+			close(updates)
+		}()
+		return updates, func() { cmds <- &cmd{clientId: id, command: CmdDisconnect{}} }
+	}
+	seqOf := func(updates <-chan Update, f func(Update) bool) int {
+		var seq int
+		waitFor(t, updates, func(u Update) bool {
+			s, ok := u.(Sequenced)
+			if !ok {
+				return false
+			}
+			seq = s.Seq
+			return f(s.Update)
+		})
+		return seq
+	}
+	isNotice := func(text string) func(Update) bool {
+		return func(u Update) bool {
+			n, ok := u.(EventNotice)
+			return ok && n.Text == text
+		}
+	}
+
+	bob, leave := join(session{numbered: true})
+	seq := seqOf(bob, func(u Update) bool { _, ok := u.(Full); return ok })
+	r.notice("one")
+	seq = seqOf(bob, isNotice("one"))
+	waitFor(t, alice, isNotice("one"))
+
+	// bob drops out and misses a notice
+	leave()
+	r.notice("two")
+	waitFor(t, alice, isNotice("two"))
+
+	again, _ := join(session{numbered: true, stream: r.stream, resume: seq})
+	if have := seqOf(again, func(Update) bool { return true }); have != seq+1 {
+		t.Errorf("resumed at %d, want %d", have, seq+1)
+	}
+	// and nothing else
+	select {
+	case u := <-again:
+		if s, ok := u.(Sequenced); ok && s.Seq <= seq+1 {
+			t.Errorf("replayed too much: %+v", u)
+		}
+	default:
+	}
+
+	// an unknown stream gets the whole board
+	fresh, _ := join(session{numbered: true, stream: "other", resume: seq})
+	seqOf(fresh, func(u Update) bool { _, ok := u.(Full); return ok })
+}
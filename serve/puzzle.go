@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/robx/triples/serve/triples"
+)
+
+const (
+	puzzleCards      = 12
+	puzzleTries      = 10000
+	maxPuzzleName    = 32
+	maxPuzzleBody    = 16 << 10
+	maxPuzzleTimes   = 1000 // on each leaderboard
+	puzzleTicketLife = 48 * time.Hour
+	dateFormat       = "2006-01-02"
+)
+
+var errTicketUsed = errors.New("this ticket has been used")
+
+var (
+	puzzleGames = map[string]triples.Type{
+		"triples":    triples.Triples,
+		"quadruples": triples.Quadruples,
+	}
+	// puzzleMatches is how many matches a daily board has
+	puzzleMatches = [2]int{6, 20}
+)
+
+// Puzzle is a daily "find all the matches" puzzle. The cards are
+// laid out in columns of three, like the boards of the other games.
+type Puzzle struct {
+	Date    string         `json:"date"`
+	Game    string         `json:"game"`
+	Cards   []triples.Card `json:"cards"`
+	Matches int            `json:"matches"`
+}
+
+// dailyPuzzle derives the puzzle for a date from nothing but the
+// date, so it is the same for everyone and survives restarts.
+func dailyPuzzle(date, game string) (Puzzle, error) {
+	typ, ok := puzzleGames[game]
+	if !ok {
+		return Puzzle{}, fmt.Errorf("unknown puzzle game %q", game)
+	}
+	if _, err := time.Parse(dateFormat, date); err != nil {
+		return Puzzle{}, err
+	}
+	h := fnv.New64a()
+	h.Write([]byte(date + "/" + game))
+	rng := rand.New(rand.NewSource(int64(h.Sum64())))
+
+	p := Puzzle{Date: date, Game: game}
+	for i := 0; i < puzzleTries; i++ {
+		p.Cards = p.Cards[:0]
+		for _, c := range rng.Perm(triples.NumCards)[:puzzleCards] {
+			p.Cards = append(p.Cards, triples.Card(c))
+		}
+		p.Matches = len(triples.Matches(typ, p.Cards))
+		if p.Matches == puzzleMatches[typ] {
+			break
+		}
+	}
+	return p, nil
+}
+
+// check verifies that found holds every match on the board.
+func (p Puzzle) check(found [][]triples.Card) error {
+	typ := puzzleGames[p.Game]
+	onBoard := map[triples.Card]bool{}
+	for _, c := range p.Cards {
+		onBoard[c] = true
+	}
+	seen := map[string]bool{}
+	for _, m := range found {
+		inMatch := map[triples.Card]bool{}
+		for _, c := range m {
+			if !onBoard[c] {
+				return fmt.Errorf("card %d is not on the board", c)
+			}
+			if inMatch[c] {
+				return fmt.Errorf("card %d picked twice", c)
+			}
+			inMatch[c] = true
+		}
+		if !triples.IsMatch(typ, m) {
+			return fmt.Errorf("%v is no %s", m, matchName(typ))
+		}
+		s := append([]triples.Card(nil), m...)
+		sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })
+		key := fmt.Sprint(s)
+		if seen[key] {
+			return fmt.Errorf("%v found twice", m)
+		}
+		seen[key] = true
+	}
+	if len(seen) != p.Matches {
+		return fmt.Errorf("found %d of %d", len(seen), p.Matches)
+	}
+	return nil
+}
+
+// Puzzles keeps the daily leaderboards, in a file if a path is given.
+// Tickets are sealed with a key made at startup, so restarting the
+// server turns away solutions to puzzles handed out before.
+type Puzzles struct {
+	mu    sync.Mutex
+	path  string
+	key   [32]byte
+	now   func() time.Time
+	times map[string][]PuzzleTime // by date and game
+	used  map[string]time.Time    // tickets that counted, by ID, with their start
+}
+
+// PuzzleTime is a player's best time for a puzzle.
+type PuzzleTime struct {
+	Name   string `json:"name"`
+	Millis int64  `json:"ms"`
+}
+
+// puzzleTicket is handed out with the puzzle, and records when
+// the player first saw it.
+type puzzleTicket struct {
+	ID    string    `json:"i"`
+	Date  string    `json:"d"`
+	Game  string    `json:"g"`
+	Start time.Time `json:"s"`
+}
+
+func openPuzzles(path string) (*Puzzles, error) {
+	ps := &Puzzles{
+		path:  path,
+		key:   genKey(),
+		now:   time.Now,
+		times: map[string][]PuzzleTime{},
+		used:  map[string]time.Time{},
+	}
+	if path == "" {
+		return ps, nil
+	}
+	bs, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ps, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bs, &ps.times); err != nil {
+		return nil, fmt.Errorf("reading puzzle times %s: %s", path, err)
+	}
+	return ps, nil
+}
+
+func (ps *Puzzles) today() string {
+	return ps.now().UTC().Format(dateFormat)
+}
+
+// record stores the solve time of a ticket, keeping only the best
+// per player, and returns the player's rank, or 0 if they didn't
+// make the leaderboard. Each ticket counts once.
+func (ps *Puzzles) record(t puzzleTicket, name string, d time.Duration) (int, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if _, ok := ps.used[t.ID]; ok {
+		return 0, errTicketUsed
+	}
+	for id, start := range ps.used {
+		// expired tickets are turned away anyway
+		if ps.now().Sub(start) > puzzleTicketLife {
+			delete(ps.used, id)
+		}
+	}
+	ps.used[t.ID] = t.Start
+	date, game := t.Date, t.Game
+	key := date + "/" + game
+	ts := ps.times[key]
+	i := 0
+	for ; i < len(ts); i++ {
+		if ts[i].Name == name {
+			break
+		}
+	}
+	if i == len(ts) {
+		ts = append(ts, PuzzleTime{Name: name, Millis: d.Milliseconds()})
+	} else if d.Milliseconds() < ts[i].Millis {
+		ts[i].Millis = d.Milliseconds()
+	}
+	sort.SliceStable(ts, func(i, j int) bool { return ts[i].Millis < ts[j].Millis })
+	if len(ts) > maxPuzzleTimes {
+		ts = ts[:maxPuzzleTimes]
+	}
+	ps.times[key] = ts
+	rank := 0
+	for i, t := range ts {
+		if t.Name == name {
+			rank = i + 1
+		}
+	}
+	if ps.path == "" {
+		return rank, nil
+	}
+	bs, err := json.Marshal(ps.times)
+	if err != nil {
+		return rank, err
+	}
+	return rank, writeFile(ps.path, bs)
+}
+
+func (ps *Puzzles) leaderboard(date, game string) []PuzzleTime {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return append([]PuzzleTime{}, ps.times[date+"/"+game]...)
+}
+
+func puzzleParam(r *http.Request) string {
+	if g := r.FormValue("game"); g != "" {
+		return g
+	}
+	return "triples"
+}
+
+func puzzleTodayHandler(ps *Puzzles) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		p, err := dailyPuzzle(ps.today(), puzzleParam(r))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, struct {
+			Puzzle
+			Ticket string `json:"ticket"`
+		}{p, seal(puzzleTicket{ID: newToken(), Date: p.Date, Game: p.Game, Start: ps.now()}, ps.key)})
+	}
+}
+
+type puzzleSolution struct {
+	Ticket string           `json:"ticket"`
+	Name   string           `json:"name"`
+	Found  [][]triples.Card `json:"found"`
+}
+
+func puzzleSolveHandler(ps *Puzzles) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		var s puzzleSolution
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPuzzleBody)).Decode(&s); err != nil {
+			http.Error(w, "bad solution: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		var t puzzleTicket
+		if err := unseal(s.Ticket, ps.key, &t); err != nil {
+			http.Error(w, "bad ticket", http.StatusBadRequest)
+			return
+		}
+		name := strings.TrimSpace(s.Name)
+		if name == "" || len([]rune(name)) > maxPuzzleName {
+			http.Error(w, "bad name", http.StatusBadRequest)
+			return
+		}
+		p, err := dailyPuzzle(t.Date, t.Game)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := p.check(s.Found); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		d := ps.now().Sub(t.Start)
+		if d > puzzleTicketLife {
+			http.Error(w, "ticket expired", http.StatusBadRequest)
+			return
+		}
+		rank, err := ps.record(t, name, d)
+		if errors.Is(err, errTicketUsed) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			log.Printf("storing puzzle time: %s", err)
+		}
+		writeJSON(w, struct {
+			Millis int64 `json:"ms"`
+			Rank   int   `json:"rank"`
+		}{d.Milliseconds(), rank})
+	}
+}
+
+func puzzleLeaderboardHandler(ps *Puzzles) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		date := r.FormValue("date")
+		if date == "" {
+			date = ps.today()
+		}
+		game := puzzleParam(r)
+		if _, ok := puzzleGames[game]; !ok {
+			http.Error(w, "unknown game", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, struct {
+			Date  string       `json:"date"`
+			Game  string       `json:"game"`
+			Times []PuzzleTime `json:"times"`
+		}{date, game, ps.leaderboard(date, game)})
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/robx/triples/serve/triples"
+)
+
+func TestDailyPuzzle(t *testing.T) {
+	for game, typ := range puzzleGames {
+		p, err := dailyPuzzle("2021-03-14", game)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q, _ := dailyPuzzle("2021-03-14", game)
+		if have, want := fmt.Sprint(q.Cards), fmt.Sprint(p.Cards); have != want {
+			t.Errorf("%s: not deterministic: have %v, want %v", game, have, want)
+		}
+		if have, want := p.Matches, puzzleMatches[typ]; have != want {
+			t.Errorf("%s: have %v, want %v", game, have, want)
+		}
+		if err := p.check(triples.Matches(typ, p.Cards)); err != nil {
+			t.Errorf("%s: %s", game, err)
+		}
+	}
+	if _, err := dailyPuzzle("yesterday", "triples"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestPuzzleCheck(t *testing.T) {
+	p, _ := dailyPuzzle("2021-03-14", "triples")
+	ms := triples.Matches(triples.Triples, p.Cards)
+	dup := append(ms[:len(ms)-1:len(ms)-1], ms[0])
+	c := p.Cards[0]
+	for name, found := range map[string][][]triples.Card{
+		"missing":   ms[1:],
+		"duplicate": dup,
+		"same card": append(ms[1:], []triples.Card{c, c, c}),
+		"off board": append(ms[1:], []triples.Card{0, 1, 2}),
+	} {
+		if err := p.check(found); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestPuzzleHandlers(t *testing.T) {
+	ps, _ := openPuzzles("")
+	now := time.Date(2021, 3, 14, 9, 0, 0, 0, time.UTC)
+	ps.now = func() time.Time { return now }
+	r := httprouter.New()
+	r.GET("/api/puzzle/today", puzzleTodayHandler(ps))
+	r.POST("/api/puzzle/solve", puzzleSolveHandler(ps))
+	r.GET("/api/puzzle/leaderboard", puzzleLeaderboardHandler(ps))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/puzzle/today", nil))
+	var p struct {
+		Puzzle
+		Ticket string `json:"ticket"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := p.Date, "2021-03-14"; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+
+	solve := func(name string, found [][]triples.Card) *httptest.ResponseRecorder {
+		bs, _ := json.Marshal(puzzleSolution{Ticket: p.Ticket, Name: name, Found: found})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("POST", "/api/puzzle/solve", strings.NewReader(string(bs))))
+		return w
+	}
+	ms := triples.Matches(triples.Triples, p.Cards)
+	if have, want := solve("Ann", ms[1:]).Code, http.StatusUnprocessableEntity; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	now = now.Add(90 * time.Second)
+	if have, want := solve("Ann", ms).Code, http.StatusOK; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	// a ticket counts once
+	if have, want := solve("Bob", ms).Code, http.StatusConflict; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/puzzle/today", nil))
+	if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(120 * time.Second)
+	if have, want := solve("Bob", ms).Code, http.StatusOK; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	if have, want := solve("Bob", ms).Code, http.StatusConflict; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	w = httptest.NewRecorder()
+	big := strings.NewReader(`{"name": "` + strings.Repeat("x", maxPuzzleBody) + `"}`)
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/api/puzzle/solve", big))
+	if have, want := w.Code, http.StatusBadRequest; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	p.Ticket = "forged"
+	if have, want := solve("Eve", ms).Code, http.StatusBadRequest; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/puzzle/leaderboard", nil))
+	var lb struct {
+		Times []PuzzleTime `json:"times"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&lb); err != nil {
+		t.Fatal(err)
+	}
+	want := []PuzzleTime{{"Ann", 90000}, {"Bob", 120000}}
+	if len(lb.Times) != len(want) {
+		t.Fatalf("have %v, want %v", lb.Times, want)
+	}
+	for i := range want {
+		if have := lb.Times[i]; have != want[i] {
+			t.Errorf("have %v, want %v", have, want[i])
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// errRejected marks delivery errors that retrying won't fix.
+var errRejected = errors.New("rejected")
+
+const (
+	maxDeliveryAttempts = 10
+	minRetryDelay       = 5 * time.Second
+	maxRetryDelay       = time.Hour
+)
+
+// ScoreQueue holds scores that are waiting to be delivered to Telegram.
+// Pending scores are kept in a file, so they survive a restart, and
+// failed deliveries are retried with exponential backoff.
+type ScoreQueue struct {
+	mu        sync.Mutex
+	path      string
+	nextID    int
+	pending   []queuedScore
+	delivered int
+	dropped   int
+	wake      chan struct{}
+}
+
+type queuedScore struct {
+	ID        int       `json:"id"`
+	Blob      Blob      `json:"blob"`
+	Score     int       `json:"score"`
+	Attempts  int       `json:"attempts"`
+	Next      time.Time `json:"next"`
+	LastError string    `json:"err,omitempty"`
+}
+
+// openScoreQueue loads the queue stored at path. An empty path
+// gives a queue that is only kept in memory.
+func openScoreQueue(path string) (*ScoreQueue, error) {
+	q := &ScoreQueue{
+		path: path,
+		wake: make(chan struct{}, 1),
+	}
+	if path == "" {
+		return q, nil
+	}
+	bs, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return q, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bs, &q.pending); err != nil {
+		return nil, fmt.Errorf("reading score queue %s: %s", path, err)
+	}
+	for _, s := range q.pending {
+		if s.ID >= q.nextID {
+			q.nextID = s.ID + 1
+		}
+	}
+	return q, nil
+}
+
+// save writes the pending scores to disk. The caller holds q.mu.
+func (q *ScoreQueue) save() error {
+	if q.path == "" {
+		return nil
+	}
+	bs, err := json.Marshal(q.pending)
+	if err != nil {
+		return err
+	}
+	return writeFile(q.path, bs)
+}
+
+// writeFile replaces the file at path with bs, such that a crash
+// leaves either the old or the new contents.
+func writeFile(path string, bs []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(bs); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// push enqueues a score, returning once it is stored.
+func (q *ScoreQueue) push(blob Blob, score int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending = append(q.pending, queuedScore{
+		ID:    q.nextID,
+		Blob:  blob,
+		Score: score,
+		Next:  time.Now(),
+	})
+	q.nextID++
+	if err := q.save(); err != nil {
+		q.pending = q.pending[:len(q.pending)-1]
+		return fmt.Errorf("saving score queue: %s", err)
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func retryDelay(attempts int) time.Duration {
+	d := minRetryDelay
+	for i := 1; i < attempts && d < maxRetryDelay; i++ {
+		d *= 2
+	}
+	if d > maxRetryDelay {
+		return maxRetryDelay
+	}
+	return d
+}
+
+// deliverDue attempts delivery of every score that is due at now,
+// and returns how long to wait until the next one is due.
+func (q *ScoreQueue) deliverDue(now time.Time, deliver func(Blob, int) error) time.Duration {
+	for {
+		q.mu.Lock()
+		var (
+			due  *queuedScore
+			wait = maxRetryDelay
+		)
+		for i := range q.pending {
+			s := &q.pending[i]
+			if !s.Next.After(now) {
+				due = s
+				break
+			}
+			if d := s.Next.Sub(now); d < wait {
+				wait = d
+			}
+		}
+		if due == nil {
+			q.mu.Unlock()
+			return wait
+		}
+		s := *due
+		q.mu.Unlock()
+
+		err := deliver(s.Blob, s.Score)
+
+		q.mu.Lock()
+		q.update(s.ID, func(p *queuedScore) bool {
+			if err == nil {
+				log.Printf("sent score %s=%d", s.Blob.FirstName, s.Score)
+				q.delivered++
+				return false
+			}
+			p.Attempts++
+			p.LastError = err.Error()
+			if errors.Is(err, errRejected) {
+				log.Printf("score %s=%d rejected: %s", s.Blob.FirstName, s.Score, err)
+				q.dropped++
+				return false
+			}
+			if p.Attempts >= maxDeliveryAttempts {
+				log.Printf("giving up on score %s=%d: %s", s.Blob.FirstName, s.Score, err)
+				q.dropped++
+				return false
+			}
+			p.Next = now.Add(retryDelay(p.Attempts))
+			log.Printf("send score %s=%d (attempt %d): %s", s.Blob.FirstName, s.Score, p.Attempts, err)
+			return true
+		})
+		if err := q.save(); err != nil {
+			log.Printf("saving score queue: %s", err)
+		}
+		q.mu.Unlock()
+	}
+}
+
+// update applies f to the pending score with the given ID,
+// removing it if f returns false. The caller holds q.mu.
+func (q *ScoreQueue) update(id int, f func(*queuedScore) bool) {
+	for i := range q.pending {
+		if q.pending[i].ID == id {
+			if !f(&q.pending[i]) {
+				q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			}
+			return
+		}
+	}
+}
+
+// run delivers queued scores forever.
+func (q *ScoreQueue) run(deliver func(Blob, int) error) {
+	for {
+		wait := q.deliverDue(time.Now(), deliver)
+		select {
+		case <-q.wake:
+		case <-time.After(wait):
+		}
+	}
+}
+
+type QueueStatus struct {
+	Pending   int               `json:"pending"`
+	Delivered int               `json:"delivered"`
+	Dropped   int               `json:"dropped"`
+	Items     []QueueStatusItem `json:"items"`
+}
+
+type QueueStatusItem struct {
+	ID        int       `json:"id"`
+	Attempts  int       `json:"attempts"`
+	Next      time.Time `json:"next"`
+	LastError string    `json:"err,omitempty"`
+}
+
+func (q *ScoreQueue) status() QueueStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	st := QueueStatus{
+		Pending:   len(q.pending),
+		Delivered: q.delivered,
+		Dropped:   q.dropped,
+		Items:     []QueueStatusItem{},
+	}
+	for _, s := range q.pending {
+		st.Items = append(st.Items, QueueStatusItem{
+			ID:        s.ID,
+			Attempts:  s.Attempts,
+			Next:      s.Next,
+			LastError: s.LastError,
+		})
+	}
+	return st
+}
+
+func queueHandler(q *ScoreQueue) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		writeJSON(w, q.status())
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestQueuePersists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "queue.json")
+
+	q, err := openScoreQueue(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.push(Blob{UserID: 1}, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.push(Blob{UserID: 2}, 7); err != nil {
+		t.Fatal(err)
+	}
+
+	q, err = openScoreQueue(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := q.status().Pending, 2; have != want {
+		t.Fatalf("have %v, want %v", have, want)
+	}
+	if err := q.push(Blob{UserID: 3}, 9); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := q.pending[2].ID, 2; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+}
+
+func TestQueueRetry(t *testing.T) {
+	q, _ := openScoreQueue("")
+	if err := q.push(Blob{UserID: 1}, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		now      = time.Now()
+		attempts = 0
+		fail     = func(Blob, int) error { attempts++; return errors.New("down") }
+		ok       = func(Blob, int) error { attempts++; return nil }
+	)
+	if have, want := q.deliverDue(now, fail), retryDelay(1); have != want {
+		t.Errorf("wait: have %v, want %v", have, want)
+	}
+	if have, want := attempts, 1; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	q.deliverDue(now.Add(time.Second), ok)
+	if have, want := attempts, 1; have != want {
+		t.Errorf("delivered early: have %v, want %v", have, want)
+	}
+	st := q.status()
+	if st.Pending != 1 || st.Items[0].Attempts != 1 || st.Items[0].LastError != "down" {
+		t.Errorf("have %+v", st)
+	}
+	q.deliverDue(now.Add(retryDelay(1)), ok)
+	if have, want := attempts, 2; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	if st := q.status(); st.Pending != 0 || st.Delivered != 1 {
+		t.Errorf("have %+v", st)
+	}
+}
+
+func TestQueueGivesUp(t *testing.T) {
+	q, _ := openScoreQueue("")
+	if err := q.push(Blob{UserID: 1}, 5); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for i := 0; i < maxDeliveryAttempts; i++ {
+		q.deliverDue(now, func(Blob, int) error { return errors.New("down") })
+		now = now.Add(maxRetryDelay)
+	}
+	if st := q.status(); st.Pending != 0 || st.Dropped != 1 {
+		t.Errorf("have %+v", st)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	if have, want := retryDelay(1), minRetryDelay; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	if have, want := retryDelay(3), 4*minRetryDelay; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	if have, want := retryDelay(100), maxRetryDelay; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+}
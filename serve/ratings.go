@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Glicko-2 parameters, see http://www.glicko.net/glicko/glicko2.pdf
+const (
+	initialRating     = 1500
+	initialDeviation  = 350
+	initialVolatility = 0.06
+	glickoScale       = 173.7178
+	glickoTau         = 0.5
+	glickoEpsilon     = 0.000001
+	maxRatingHistory  = 100
+)
+
+// Identifier tells which player joined with a key,
+// if the key identifies one.
+type Identifier func(key string) (string, bool)
+
+// Ratings are Glicko-2 skill ratings of identified players,
+// updated from the results of multiplayer games, and kept in
+// a file if a path is given.
+type Ratings struct {
+	mu       sync.Mutex
+	path     string
+	identify Identifier
+	now      func() time.Time
+	players  map[string]*PlayerRating
+}
+
+type PlayerRating struct {
+	ID         string        `json:"id"`
+	Public     string        `json:"public"` // the ID the API shows
+	Name       string        `json:"name"`
+	Rating     float64       `json:"rating"`
+	Deviation  float64       `json:"rd"`
+	Volatility float64       `json:"vol"`
+	Games      int           `json:"games"`
+	History    []RatingPoint `json:"history,omitempty"`
+}
+
+// PublicRating is a rating as the API shows it, under an ID
+// that doesn't give away the player's Telegram account.
+type PublicRating struct {
+	ID         string        `json:"id"`
+	Name       string        `json:"name"`
+	Rating     float64       `json:"rating"`
+	Deviation  float64       `json:"rd"`
+	Volatility float64       `json:"vol"`
+	Games      int           `json:"games"`
+	History    []RatingPoint `json:"history,omitempty"`
+}
+
+func (p *PlayerRating) public() PublicRating {
+	return PublicRating{
+		ID:         p.Public,
+		Name:       p.Name,
+		Rating:     p.Rating,
+		Deviation:  p.Deviation,
+		Volatility: p.Volatility,
+		Games:      p.Games,
+	}
+}
+
+// RatingPoint is a player's rating after a game.
+type RatingPoint struct {
+	Time      time.Time `json:"time"`
+	Rating    float64   `json:"rating"`
+	Deviation float64   `json:"rd"`
+}
+
+func openRatings(path string, identify Identifier) (*Ratings, error) {
+	rs := &Ratings{
+		path:     path,
+		identify: identify,
+		now:      time.Now,
+		players:  map[string]*PlayerRating{},
+	}
+	if path == "" {
+		return rs, nil
+	}
+	bs, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return rs, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bs, &rs.players); err != nil {
+		return nil, fmt.Errorf("reading ratings %s: %s", path, err)
+	}
+	for _, p := range rs.players {
+		if p.Public == "" {
+			p.Public = newPublicID()
+		}
+	}
+	return rs, nil
+}
+
+// lookup gives the ratings of the players with keys
+// that have played a rated game.
+func (rs *Ratings) lookup(keys map[string]string) map[string]int {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	out := map[string]int{}
+	for name, key := range keys {
+		id, ok := rs.identify(key)
+		if !ok {
+			continue
+		}
+		if p, ok := rs.players[id]; ok {
+			out[name] = int(math.Round(p.Rating))
+		}
+	}
+	return out
+}
+
+// record rates a finished game. Every pair of identified players
+// counts as a win, loss or draw by their final scores.
+func (rs *Ratings) record(keys map[string]string, scores map[string]int) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	var (
+		ids   []string
+		names = map[string]string{}
+	)
+	for name, key := range keys {
+		id, ok := rs.identify(key)
+		if !ok {
+			continue
+		}
+		if _, dup := names[id]; dup {
+			continue
+		}
+		if _, played := scores[name]; !played {
+			continue
+		}
+		ids = append(ids, id)
+		names[id] = name
+	}
+	if len(ids) < 2 {
+		return nil
+	}
+	sort.Strings(ids)
+
+	before := map[string]PlayerRating{}
+	for _, id := range ids {
+		p, ok := rs.players[id]
+		if !ok {
+			p = &PlayerRating{
+				ID:         id,
+				Public:     newPublicID(),
+				Rating:     initialRating,
+				Deviation:  initialDeviation,
+				Volatility: initialVolatility,
+			}
+			rs.players[id] = p
+		}
+		before[id] = *p
+	}
+	now := rs.now()
+	for _, id := range ids {
+		var results []glickoResult
+		for _, other := range ids {
+			if other == id {
+				continue
+			}
+			s := 0.5
+			if a, b := scores[names[id]], scores[names[other]]; a > b {
+				s = 1
+			} else if a < b {
+				s = 0
+			}
+			results = append(results, glickoResult{opponent: before[other], score: s})
+		}
+		p := rs.players[id]
+		*p = glicko(before[id], results)
+		p.Name = names[id]
+		p.Games++
+		p.History = append(p.History, RatingPoint{Time: now, Rating: p.Rating, Deviation: p.Deviation})
+		if len(p.History) > maxRatingHistory {
+			p.History = p.History[len(p.History)-maxRatingHistory:]
+		}
+	}
+	if rs.path == "" {
+		return nil
+	}
+	bs, err := json.Marshal(rs.players)
+	if err != nil {
+		return err
+	}
+	return writeFile(rs.path, bs)
+}
+
+type glickoResult struct {
+	opponent PlayerRating
+	score    float64 // 1 for a win, 0.5 for a draw, 0 for a loss
+}
+
+// glicko is the Glicko-2 update of p's rating after one rating
+// period with the given results.
+func glicko(p PlayerRating, results []glickoResult) PlayerRating {
+	var (
+		mu    = (p.Rating - initialRating) / glickoScale
+		phi   = p.Deviation / glickoScale
+		sigma = p.Volatility
+	)
+	g := func(phi float64) float64 {
+		return 1 / math.Sqrt(1+3*phi*phi/(math.Pi*math.Pi))
+	}
+	var vinv, sum float64
+	for _, r := range results {
+		muj := (r.opponent.Rating - initialRating) / glickoScale
+		gj := g(r.opponent.Deviation / glickoScale)
+		e := 1 / (1 + math.Exp(-gj*(mu-muj)))
+		vinv += gj * gj * e * (1 - e)
+		sum += gj * (r.score - e)
+	}
+	v := 1 / vinv
+	delta := v * sum
+
+	// the new volatility, by the Illinois algorithm
+	a := math.Log(sigma * sigma)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		d := phi*phi + v + ex
+		return ex*(delta*delta-d)/(2*d*d) - (x-a)/(glickoTau*glickoTau)
+	}
+	A := a
+	var B float64
+	if delta*delta > phi*phi+v {
+		B = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*glickoTau) < 0 {
+			k++
+		}
+		B = a - k*glickoTau
+	}
+	fA, fB := f(A), f(B)
+	for math.Abs(B-A) > glickoEpsilon {
+		C := A + (A-B)*fA/(fB-fA)
+		fC := f(C)
+		if fC*fB <= 0 {
+			A, fA = B, fB
+		} else {
+			fA /= 2
+		}
+		B, fB = C, fC
+	}
+	sigma = math.Exp(A / 2)
+
+	phiStar := math.Sqrt(phi*phi + sigma*sigma)
+	phi = 1 / math.Sqrt(1/(phiStar*phiStar)+1/v)
+	mu += phi * phi * sum
+
+	p.Rating = mu*glickoScale + initialRating
+	p.Deviation = phi * glickoScale
+	p.Volatility = sigma
+	return p
+}
+
+func newPublicID() string {
+	return newToken()[:16]
+}
+
+func ratingsHandler(rs *Ratings) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		rs.mu.Lock()
+		list := []PublicRating{}
+		for _, p := range rs.players {
+			list = append(list, p.public())
+		}
+		rs.mu.Unlock()
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Rating != list[j].Rating {
+				return list[i].Rating > list[j].Rating
+			}
+			return list[i].ID < list[j].ID
+		})
+		writeJSON(w, list)
+	}
+}
+
+func playerRatingHandler(rs *Ratings) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		rs.mu.Lock()
+		var (
+			pp PublicRating
+			ok bool
+		)
+		for _, p := range rs.players {
+			if p.Public == ps.ByName("id") {
+				pp, ok = p.public(), true
+				pp.History = append([]RatingPoint(nil), p.History...)
+				break
+			}
+		}
+		rs.mu.Unlock()
+		if !ok {
+			http.Error(w, "no such player", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, pp)
+	}
+}
+
+func (rs *Ratings) results(keys map[string]string, scores map[string]int) {
+	if err := rs.record(keys, scores); err != nil {
+		log.Printf("storing ratings: %s", err)
+	}
+}
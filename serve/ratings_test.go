@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestGlicko(t *testing.T) {
+	// the example from Glickman's paper
+	p := PlayerRating{Rating: 1500, Deviation: 200, Volatility: 0.06}
+	p = glicko(p, []glickoResult{
+		{PlayerRating{Rating: 1400, Deviation: 30}, 1},
+		{PlayerRating{Rating: 1550, Deviation: 100}, 0},
+		{PlayerRating{Rating: 1700, Deviation: 300}, 0},
+	})
+	for _, tc := range []struct {
+		name       string
+		have, want float64
+		within     float64
+	}{
+		{"rating", p.Rating, 1464.06, 0.01},
+		{"deviation", p.Deviation, 151.52, 0.01},
+		{"volatility", p.Volatility, 0.05999, 0.00001},
+	} {
+		if math.Abs(tc.have-tc.want) > tc.within {
+			t.Errorf("%s: have %v, want %v", tc.name, tc.have, tc.want)
+		}
+	}
+}
+
+func TestRecordRatings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ratings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ratings.json")
+
+	// keys are just the player ids here
+	identify := func(key string) (string, bool) { return key, key != "" }
+	rs, err := openRatings(path, identify)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := map[string]string{"Ann": "1", "Bob": "2", "Eve": "3", "Tom": ""}
+	if err := rs.record(keys, map[string]int{"Ann": 5, "Bob": 3, "Eve": 3, "Tom": 9}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.record(map[string]string{"Ann": "1"}, map[string]int{"Ann": 5}); err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err = openRatings(path, identify)
+	if err != nil {
+		t.Fatal(err)
+	}
+	have := rs.lookup(keys)
+	if len(have) != 3 {
+		t.Fatalf("have %v, want three ratings", have)
+	}
+	if !(have["Ann"] > have["Bob"] && have["Bob"] == have["Eve"] && have["Eve"] < initialRating) {
+		t.Errorf("unexpected ratings %v", have)
+	}
+	// a game on your own doesn't count
+	if n := rs.players["1"].Games; n != 1 {
+		t.Errorf("have %v, want %v", n, 1)
+	}
+
+	r := httprouter.New()
+	r.GET("/api/ratings", ratingsHandler(rs))
+	r.GET("/api/ratings/:id", playerRatingHandler(rs))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/ratings", nil))
+	var list []PublicRating
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 || list[0].Name != "Ann" || list[0].History != nil {
+		t.Errorf("unexpected list %+v", list)
+	}
+	for _, p := range list {
+		if p.ID == "" || p.ID == "1" || p.ID == "2" || p.ID == "3" {
+			t.Errorf("%s: public ID %q", p.Name, p.ID)
+		}
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/ratings/"+rs.players["2"].Public, nil))
+	var bob PublicRating
+	if err := json.NewDecoder(w.Body).Decode(&bob); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(bob.History), 1; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/ratings/2", nil))
+	if have, want := w.Code, http.StatusNotFound; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+}
@@ -0,0 +1,48 @@
+package render
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	digitAdvance = 6
+	labelStroke  = 1.2
+)
+
+// segments are the lines of a seven-segment digit of size 4x7,
+// from the top clockwise, then the middle.
+var segments = [7][2]point{
+	{{0, 0}, {4, 0}},
+	{{4, 0}, {4, 3.5}},
+	{{4, 3.5}, {4, 7}},
+	{{0, 7}, {4, 7}},
+	{{0, 3.5}, {0, 7}},
+	{{0, 0}, {0, 3.5}},
+	{{0, 3.5}, {4, 3.5}},
+}
+
+var digitSegments = [10]string{
+	"abcdef", "bc", "abged", "abgcd", "fgbc",
+	"afgcd", "afgedc", "abc", "abcdefg", "abcdfg",
+}
+
+// labelPath draws a label in the top left corner of a card,
+// as a path in card coordinates.
+func labelPath(label string) (string, error) {
+	var (
+		b    strings.Builder
+		orig = point{-cardWidth/2 + 4, -cardHeight/2 + 4}
+	)
+	for i, c := range label {
+		if c < '0' || c > '9' {
+			return "", fmt.Errorf("invalid label %q", label)
+		}
+		off := orig.add(point{float64(i * digitAdvance), 0})
+		for _, seg := range digitSegments[c-'0'] {
+			p, q := segments[seg-'a'][0].add(off), segments[seg-'a'][1].add(off)
+			fmt.Fprintf(&b, "M%g,%g L%g,%g ", p.X, p.Y, q.X, q.Y)
+		}
+	}
+	return strings.TrimSpace(b.String()), nil
+}
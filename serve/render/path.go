@@ -0,0 +1,236 @@
+package render
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type point struct {
+	X, Y float64
+}
+
+func (p point) add(q point) point      { return point{p.X + q.X, p.Y + q.Y} }
+func (p point) sub(q point) point      { return point{p.X - q.X, p.Y - q.Y} }
+func (p point) mul(f float64) point    { return point{f * p.X, f * p.Y} }
+func (p point) reflect(c point) point  { return c.mul(2).sub(p) }
+func (p point) len() float64           { return math.Hypot(p.X, p.Y) }
+func (p point) perp() point            { return point{-p.Y, p.X} }
+func lerp(p, q point, t float64) point { return p.add(q.sub(p).mul(t)) }
+
+// subpath is a flattened piece of an SVG path.
+type subpath struct {
+	pts    []point
+	closed bool
+}
+
+const curveSteps = 16
+
+// parsePath flattens an SVG path. Only the commands that the
+// card shapes use are supported: M, L, C, S and Z, absolute
+// and relative.
+func parsePath(d string) ([]subpath, error) {
+	toks, err := tokenize(d)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		out  []subpath
+		cur  = -1 // index of the current subpath
+		pos  point
+		ctrl point // last cubic control point, for S
+		cmd  byte
+		i    = 0
+	)
+	num := func() (float64, error) {
+		if i >= len(toks) || toks[i].cmd != 0 {
+			return 0, fmt.Errorf("path %q: expected number", d)
+		}
+		i++
+		return toks[i-1].num, nil
+	}
+	pt := func(rel bool) (point, error) {
+		x, err := num()
+		if err != nil {
+			return point{}, err
+		}
+		y, err := num()
+		if err != nil {
+			return point{}, err
+		}
+		if rel {
+			return pos.add(point{x, y}), nil
+		}
+		return point{x, y}, nil
+	}
+	for i < len(toks) {
+		if toks[i].cmd != 0 {
+			cmd = toks[i].cmd
+			i++
+		} else if cmd == 0 {
+			return nil, fmt.Errorf("path %q: expected command", d)
+		}
+		rel := cmd >= 'a'
+		switch cmd {
+		case 'M', 'm':
+			p, err := pt(rel)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, subpath{pts: []point{p}})
+			cur = len(out) - 1
+			pos, ctrl = p, p
+			// further coordinate pairs are implicit lineto commands
+			cmd = cmd - 'M' + 'L'
+		case 'L', 'l':
+			p, err := pt(rel)
+			if err != nil {
+				return nil, err
+			}
+			if cur < 0 {
+				return nil, fmt.Errorf("path %q: missing moveto", d)
+			}
+			out[cur].pts = append(out[cur].pts, p)
+			pos, ctrl = p, p
+		case 'C', 'c', 'S', 's':
+			var c1 point
+			if cmd == 'C' || cmd == 'c' {
+				if c1, err = pt(rel); err != nil {
+					return nil, err
+				}
+			} else {
+				c1 = ctrl.reflect(pos)
+			}
+			c2, err := pt(rel)
+			if err != nil {
+				return nil, err
+			}
+			p, err := pt(rel)
+			if err != nil {
+				return nil, err
+			}
+			if cur < 0 {
+				return nil, fmt.Errorf("path %q: missing moveto", d)
+			}
+			for k := 1; k <= curveSteps; k++ {
+				t := float64(k) / curveSteps
+				a, b, c := lerp(pos, c1, t), lerp(c1, c2, t), lerp(c2, p, t)
+				out[cur].pts = append(out[cur].pts, lerp(lerp(a, b, t), lerp(b, c, t), t))
+			}
+			pos, ctrl = p, c2
+		case 'Z', 'z':
+			if cur < 0 {
+				return nil, fmt.Errorf("path %q: missing moveto", d)
+			}
+			out[cur].closed = true
+			pos, ctrl = out[cur].pts[0], out[cur].pts[0]
+			cmd = 0
+		default:
+			return nil, fmt.Errorf("path %q: unsupported command %c", d, cmd)
+		}
+	}
+	return out, nil
+}
+
+type token struct {
+	cmd byte
+	num float64
+}
+
+func tokenize(d string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(d); {
+		c := d[i]
+		switch {
+		case c == ' ' || c == ',' || c == '\t' || c == '\n':
+			i++
+		case strings.IndexByte("MmLlCcSsZz", c) >= 0:
+			toks = append(toks, token{cmd: c})
+			i++
+		default:
+			j := i + 1
+			for j < len(d) && strings.IndexByte("0123456789.eE", d[j]) >= 0 {
+				j++
+			}
+			f, err := strconv.ParseFloat(d[i:j], 64)
+			if err != nil {
+				return nil, fmt.Errorf("path %q: %s", d, err)
+			}
+			toks = append(toks, token{num: f})
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+// area is the signed area of a polygon, positive
+// for clockwise polygons in SVG coordinates.
+func area(pts []point) float64 {
+	a := 0.0
+	for i := range pts {
+		p, q := pts[i], pts[(i+1)%len(pts)]
+		a += p.X*q.Y - q.X*p.Y
+	}
+	return a / 2
+}
+
+// oriented returns pts with positive orientation, so that
+// overlapping polygons add up under the nonzero rule.
+func oriented(pts []point) []point {
+	if area(pts) >= 0 {
+		return pts
+	}
+	out := make([]point, len(pts))
+	for i, p := range pts {
+		out[len(pts)-1-i] = p
+	}
+	return out
+}
+
+// stroke turns the outline of a subpath of the given width
+// into polygons with round joins.
+func stroke(sp subpath, width float64) [][]point {
+	var (
+		polys [][]point
+		r     = width / 2
+		pts   = sp.pts
+	)
+	if sp.closed && len(pts) > 1 {
+		pts = append(append([]point(nil), pts...), pts[0])
+	}
+	for i := 0; i+1 < len(pts); i++ {
+		a, b := pts[i], pts[i+1]
+		l := b.sub(a).len()
+		if l == 0 {
+			continue
+		}
+		n := b.sub(a).perp().mul(r / l)
+		polys = append(polys, oriented([]point{a.add(n), b.add(n), b.sub(n), a.sub(n)}))
+	}
+	for i := 1; i+1 < len(pts); i++ {
+		polys = append(polys, disc(pts[i], r))
+	}
+	if sp.closed && len(pts) > 1 {
+		polys = append(polys, disc(pts[0], r))
+	}
+	return polys
+}
+
+func disc(c point, r float64) []point {
+	const n = 8
+	pts := make([]point, n)
+	for k := range pts {
+		a := 2 * math.Pi * float64(k) / n
+		pts[k] = c.add(point{r * math.Cos(a), r * math.Sin(a)})
+	}
+	return pts
+}
+
+func fillPolys(sps []subpath) [][]point {
+	var polys [][]point
+	for _, sp := range sps {
+		polys = append(polys, sp.pts)
+	}
+	return polys
+}
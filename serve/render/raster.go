@@ -0,0 +1,150 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sort"
+)
+
+// subsamples is the number of scanlines sampled per pixel row.
+const subsamples = 5
+
+// mask holds the coverage of a region of an image.
+type mask struct {
+	r   image.Rectangle
+	cov []float32
+}
+
+func (m *mask) at(x, y int) float32 {
+	if !(image.Point{x, y}).In(m.r) {
+		return 0
+	}
+	return m.cov[(y-m.r.Min.Y)*m.r.Dx()+x-m.r.Min.X]
+}
+
+type edge struct {
+	a, b point
+}
+
+// rasterize computes the coverage of polys under the nonzero
+// winding rule, clipped to bounds. Coverage is exact along
+// scanlines and sampled vertically.
+func rasterize(polys [][]point, bounds image.Rectangle) *mask {
+	var (
+		edges []edge
+		minX  = math.Inf(1)
+		minY  = math.Inf(1)
+		maxX  = math.Inf(-1)
+		maxY  = math.Inf(-1)
+	)
+	for _, poly := range polys {
+		for i := range poly {
+			a, b := poly[i], poly[(i+1)%len(poly)]
+			if a.Y != b.Y {
+				edges = append(edges, edge{a, b})
+			}
+			minX, maxX = math.Min(minX, a.X), math.Max(maxX, a.X)
+			minY, maxY = math.Min(minY, a.Y), math.Max(maxY, a.Y)
+		}
+	}
+	r := image.Rect(
+		int(math.Floor(minX)), int(math.Floor(minY)),
+		int(math.Ceil(maxX))+1, int(math.Ceil(maxY))+1,
+	).Intersect(bounds)
+	m := &mask{r: r, cov: make([]float32, r.Dx()*r.Dy())}
+	if r.Empty() {
+		return m
+	}
+
+	type crossing struct {
+		x   float64
+		dir int
+	}
+	var xs []crossing
+	for py := r.Min.Y; py < r.Max.Y; py++ {
+		row := m.cov[(py-r.Min.Y)*r.Dx() : (py-r.Min.Y+1)*r.Dx()]
+		for s := 0; s < subsamples; s++ {
+			y := float64(py) + (float64(s)+0.5)/subsamples
+			xs = xs[:0]
+			for _, e := range edges {
+				dir := 1
+				a, b := e.a, e.b
+				if a.Y > b.Y {
+					a, b, dir = b, a, -1
+				}
+				if y < a.Y || y >= b.Y {
+					continue
+				}
+				x := a.X + (y-a.Y)*(b.X-a.X)/(b.Y-a.Y)
+				xs = append(xs, crossing{x, dir})
+			}
+			sort.Slice(xs, func(i, j int) bool { return xs[i].x < xs[j].x })
+			var (
+				winding = 0
+				start   float64
+			)
+			for _, c := range xs {
+				if winding == 0 {
+					start = c.x
+				}
+				winding += c.dir
+				if winding == 0 {
+					addSpan(row, r.Min.X, start, c.x, 1.0/subsamples)
+				}
+			}
+		}
+	}
+	for i, c := range m.cov {
+		if c > 1 {
+			m.cov[i] = 1
+		}
+	}
+	return m
+}
+
+// addSpan adds coverage f to the pixels of row between x0 and x1.
+func addSpan(row []float32, offset int, x0, x1, f float64) {
+	x0 -= float64(offset)
+	x1 -= float64(offset)
+	if x0 < 0 {
+		x0 = 0
+	}
+	if max := float64(len(row)); x1 > max {
+		x1 = max
+	}
+	for px := int(math.Floor(x0)); float64(px) < x1; px++ {
+		l := math.Max(x0, float64(px))
+		h := math.Min(x1, float64(px+1))
+		if h > l {
+			row[px] += float32(f * (h - l))
+		}
+	}
+}
+
+// paint blends col into img with the coverage of m,
+// further restricted by clip if it is not nil.
+func paint(img *image.RGBA, m *mask, clip *mask, col color.RGBA) {
+	for y := m.r.Min.Y; y < m.r.Max.Y; y++ {
+		for x := m.r.Min.X; x < m.r.Max.X; x++ {
+			a := m.at(x, y)
+			if clip != nil {
+				a *= clip.at(x, y)
+			}
+			a *= float32(col.A) / 255
+			if a <= 0 {
+				continue
+			}
+			i := img.PixOffset(x, y)
+			px := img.Pix[i : i+4 : i+4]
+			px[0] = blend(px[0], col.R, a)
+			px[1] = blend(px[1], col.G, a)
+			px[2] = blend(px[2], col.B, a)
+			px[3] = blend(px[3], 255, a)
+		}
+	}
+}
+
+func blend(dst, src uint8, a float32) uint8 {
+	return uint8(float32(dst)*(1-a) + float32(src)*a + 0.5)
+}
@@ -0,0 +1,339 @@
+// Package render draws triples cards and boards, both as SVG
+// and as PNG images. The drawing follows the classic style of
+// the Elm client in client/src/Graphics.
+//
+// Cards use the same integer encoding as the game server:
+// the base 3 digits of a card 0 <= c < 81 are, from least
+// significant, its color, count, shape and filling.
+package render
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	cardWidth    = 50
+	cardHeight   = 80
+	cornerRadius = 6
+	cellWidth    = 60
+	cellHeight   = 90
+	defaultRows  = 3
+)
+
+// MaxPixels bounds the size of images drawn by Image. It leaves room
+// for 27 columns of three cards at scale 2.
+const MaxPixels = 1 << 21
+
+// Style is a color scheme for drawing cards.
+type Style struct {
+	Symbols    [3]color.RGBA // the three card colors
+	Foreground color.RGBA    // card border
+	Background color.RGBA    // card background
+	Table      color.RGBA    // behind the cards
+}
+
+// Classic is the classic set card color scheme.
+var Classic = Style{
+	Symbols: [3]color.RGBA{
+		{229, 46, 37, 255},
+		{72, 128, 52, 255},
+		{116, 44, 177, 255},
+	},
+	Foreground: color.RGBA{0, 0, 0, 255},
+	Background: color.RGBA{255, 255, 255, 255},
+	Table:      color.RGBA{255, 255, 255, 255},
+}
+
+var (
+	shapePaths = [3]string{
+		// diamond
+		"M-18,0 L0,6 L18,0 L0,-6 Z",
+		// oval
+		"M-12,-6 L12,-6 C15.314,-6 18,-3.314 18,0 C18,3.314 15.314,6 12,6 " +
+			"L-12,6 C-15.314,6 -18,3.314 -18,0 C-18,-3.314 -15.314,-6 -12,-6 Z",
+		// squiggle
+		"M-18,0 C-18,-4 -12,-6.5 -10,-6.5 C-3,-6.5 1,-2.5 7,-2.5 " +
+			"C11,-2.5 13,-6 15,-6 S18,-4 18,0 S12,6.5 10,6.5 " +
+			"C3,6.5 -1,2.5 -7,2.5 C-11,2.5 -13,6 -15,6 S-18,4 -18,0 Z",
+	}
+	shadePath = "M-17,8 l0,-16" + strings.Repeat(" m1,16 l0,-16", 34)
+	cardPath  = roundedRect(cardWidth, cardHeight, cornerRadius)
+
+	shapes  [3][]subpath
+	shade   []subpath
+	outline []subpath
+)
+
+func init() {
+	for i, d := range shapePaths {
+		shapes[i] = mustParse(d)
+	}
+	shade = mustParse(shadePath)
+	outline = mustParse(cardPath)
+}
+
+func mustParse(d string) []subpath {
+	sps, err := parsePath(d)
+	if err != nil {
+		panic(err)
+	}
+	return sps
+}
+
+// roundedRect is a path for a w by h rectangle centered at the origin.
+func roundedRect(w, h, r float64) string {
+	var (
+		x = w/2 - r
+		y = h/2 - r
+		k = 0.5523 * r
+		f = func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	)
+	return fmt.Sprintf("M%s,%s L%s,%s C%s,%s %s,%s %s,%s L%s,%s C%s,%s %s,%s %s,%s "+
+		"L%s,%s C%s,%s %s,%s %s,%s L%s,%s C%s,%s %s,%s %s,%s Z",
+		f(-x), f(-h/2), f(x), f(-h/2),
+		f(x+k), f(-h/2), f(w/2), f(-y-k), f(w/2), f(-y),
+		f(w/2), f(y),
+		f(w/2), f(y+k), f(x+k), f(h/2), f(x), f(h/2),
+		f(-x), f(h/2),
+		f(-x-k), f(h/2), f(-w/2), f(y+k), f(-w/2), f(y),
+		f(-w/2), f(-y),
+		f(-w/2), f(-y-k), f(-x-k), f(-h/2), f(-x), f(-h/2),
+	)
+}
+
+type properties struct {
+	color, count, shape, fill int
+}
+
+func decode(c int) (properties, error) {
+	if c < 0 || c >= 81 {
+		return properties{}, fmt.Errorf("invalid card %d", c)
+	}
+	return properties{
+		color: c % 3,
+		count: c / 3 % 3,
+		shape: c / 9 % 3,
+		fill:  c / 27 % 3,
+	}, nil
+}
+
+// locations are the vertical offsets of the symbols on a card.
+func locations(count int) []float64 {
+	switch count {
+	case 0:
+		return []float64{0}
+	case 1:
+		return []float64{10, -10}
+	default:
+		return []float64{20, 0, -20}
+	}
+}
+
+// Placed is a card at a board position. Boards are laid out in
+// columns, with X the column and Y the row. If Label is set, it
+// is written in the top left corner of the card; labels may only
+// contain digits.
+type Placed struct {
+	X, Y  int
+	Card  int
+	Label string
+}
+
+// Board is a set of cards to draw.
+type Board struct {
+	Columns int // minimum number of columns
+	Rows    int // number of rows, 3 if zero
+	Cards   []Placed
+}
+
+// Single is a board holding just the one card.
+func Single(card int) Board {
+	return Board{
+		Columns: 1,
+		Rows:    1,
+		Cards:   []Placed{{Card: card}},
+	}
+}
+
+func (b Board) size() (cols, rows int) {
+	cols, rows = b.Columns, b.Rows
+	if rows == 0 {
+		rows = defaultRows
+	}
+	for _, p := range b.Cards {
+		if p.X+1 > cols {
+			cols = p.X + 1
+		}
+		if p.Y+1 > rows {
+			rows = p.Y + 1
+		}
+	}
+	return cols, rows
+}
+
+func (b Board) validate() error {
+	for _, p := range b.Cards {
+		if _, err := decode(p.Card); err != nil {
+			return err
+		}
+		if p.X < 0 || p.Y < 0 {
+			return fmt.Errorf("invalid position %d,%d", p.X, p.Y)
+		}
+		if _, err := labelPath(p.Label); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func center(p Placed) point {
+	return point{
+		X: cellWidth*float64(p.X) + cellWidth/2,
+		Y: cellHeight*float64(p.Y) + cellHeight/2,
+	}
+}
+
+func svgColor(c color.RGBA) string {
+	return fmt.Sprintf("rgb(%d,%d,%d)", c.R, c.G, c.B)
+}
+
+// WriteSVG writes b as an SVG document.
+func WriteSVG(w io.Writer, b Board, st Style) error {
+	if err := b.validate(); err != nil {
+		return err
+	}
+	cols, rows := b.size()
+	width, height := cols*cellWidth, rows*cellHeight
+
+	var s strings.Builder
+	fmt.Fprintf(&s, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`,
+		width, height, width, height)
+	s.WriteString(`<defs><filter id="dropshadow">` +
+		`<feGaussianBlur in="SourceAlpha" stdDeviation="1"/>` +
+		`<feOffset dx="2" dy="2" result="offsetblur"/>` +
+		`<feComponentTransfer><feFuncA type="linear" slope="0.5"/></feComponentTransfer>` +
+		`<feMerge><feMergeNode/><feMergeNode in="SourceGraphic"/></feMerge>` +
+		`</filter>`)
+	for i, d := range shapePaths {
+		fmt.Fprintf(&s, `<clipPath id="clip%d"><path d="%s"/></clipPath>`, i, d)
+	}
+	s.WriteString(`</defs>`)
+	fmt.Fprintf(&s, `<rect width="%d" height="%d" fill="%s"/>`, width, height, svgColor(st.Table))
+	for _, p := range b.Cards {
+		props, _ := decode(p.Card)
+		c := center(p)
+		fmt.Fprintf(&s, `<g transform="translate(%g,%g)">`, c.X, c.Y)
+		fmt.Fprintf(&s, `<rect x="%d" y="%d" width="%d" height="%d" rx="%d" ry="%d" `+
+			`stroke="%s" stroke-width="0.2" fill="%s" style="filter: url(#dropshadow);"/>`,
+			-cardWidth/2, -cardHeight/2, cardWidth, cardHeight, cornerRadius, cornerRadius,
+			svgColor(st.Foreground), svgColor(st.Background))
+		col := svgColor(st.Symbols[props.color])
+		fill := "none"
+		if props.fill == 0 {
+			fill = col
+		}
+		for _, y := range locations(props.count) {
+			fmt.Fprintf(&s, `<g transform="translate(0,%g)">`, y)
+			if props.fill == 1 {
+				fmt.Fprintf(&s, `<g clip-path="url(#clip%d)" stroke="%s" stroke-width="0.3"><path d="%s"/></g>`,
+					props.shape, col, shadePath)
+			}
+			fmt.Fprintf(&s, `<g stroke="%s" stroke-width="1.5" fill="%s"><path d="%s"/></g>`,
+				col, fill, shapePaths[props.shape])
+			s.WriteString(`</g>`)
+		}
+		if p.Label != "" {
+			d, _ := labelPath(p.Label)
+			fmt.Fprintf(&s, `<path d="%s" stroke="%s" stroke-width="%g" stroke-linecap="round" stroke-linejoin="round" fill="none"/>`,
+				d, svgColor(st.Foreground), labelStroke)
+		}
+		s.WriteString(`</g>`)
+	}
+	s.WriteString(`</svg>`)
+	_, err := io.WriteString(w, s.String())
+	return err
+}
+
+// Image draws b at the given scale, in pixels per SVG unit.
+func Image(b Board, st Style, scale float64) (*image.RGBA, error) {
+	if err := b.validate(); err != nil {
+		return nil, err
+	}
+	if scale <= 0 {
+		return nil, fmt.Errorf("invalid scale %g", scale)
+	}
+	cols, rows := b.size()
+	w, h := float64(cols*cellWidth)*scale+0.5, float64(rows*cellHeight)*scale+0.5
+	if w*h > MaxPixels {
+		return nil, fmt.Errorf("image too large: %.0fx%.0f", w, h)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, int(w), int(h)))
+	bounds := img.Bounds()
+	draw.Draw(img, bounds, image.NewUniform(st.Table), image.Point{}, draw.Src)
+
+	for _, p := range b.Cards {
+		var (
+			props, _ = decode(p.Card)
+			c        = center(p)
+			col      = st.Symbols[props.color]
+		)
+		place := func(polys [][]point, off point) [][]point {
+			out := make([][]point, len(polys))
+			for i, poly := range polys {
+				out[i] = make([]point, len(poly))
+				for j, q := range poly {
+					out[i][j] = q.add(c).add(off).mul(scale)
+				}
+			}
+			return out
+		}
+		strokes := func(sps []subpath, width float64) [][]point {
+			var polys [][]point
+			for _, sp := range sps {
+				polys = append(polys, stroke(sp, width)...)
+			}
+			return polys
+		}
+
+		card := fillPolys(outline)
+		paint(img, rasterize(place(card, point{2, 2}), bounds), nil, color.RGBA{0, 0, 0, 96})
+		paint(img, rasterize(place(card, point{}), bounds), nil, st.Background)
+		paint(img, rasterize(place(strokes(outline, 0.2), point{}), bounds), nil, st.Foreground)
+
+		for _, y := range locations(props.count) {
+			off := point{0, y}
+			shape := shapes[props.shape]
+			switch props.fill {
+			case 0:
+				paint(img, rasterize(place(fillPolys(shape), off), bounds), nil, col)
+			case 1:
+				clip := rasterize(place(fillPolys(shape), off), bounds)
+				paint(img, rasterize(place(strokes(shade, 0.3), off), bounds), clip, col)
+			}
+			paint(img, rasterize(place(strokes(shape, 1.5), off), bounds), nil, col)
+		}
+		if p.Label != "" {
+			d, _ := labelPath(p.Label)
+			label := mustParse(d)
+			paint(img, rasterize(place(strokes(label, labelStroke), point{}), bounds), nil, st.Foreground)
+		}
+	}
+	return img, nil
+}
+
+// WritePNG writes b as a PNG image, at the given scale
+// in pixels per SVG unit.
+func WritePNG(w io.Writer, b Board, st Style, scale float64) error {
+	img, err := Image(b, st, scale)
+	if err != nil {
+		return err
+	}
+	return png.Encode(w, img)
+}
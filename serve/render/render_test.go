@@ -0,0 +1,131 @@
+package render
+
+import (
+	"bytes"
+	"encoding/xml"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	sps, err := parsePath("M0,0 L10,0 l0,10 Z m1,1 l2,2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(sps), 2; have != want {
+		t.Fatalf("have %v, want %v", have, want)
+	}
+	if have, want := sps[0].pts[2], (point{10, 10}); have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	if !sps[0].closed || sps[1].closed {
+		t.Errorf("closed: have %v, %v", sps[0].closed, sps[1].closed)
+	}
+	if have, want := sps[1].pts[1], (point{3, 3}); have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	for _, d := range []string{"L1,2", "M1", "M0,0 A1,1 0 0 1 2,2"} {
+		if _, err := parsePath(d); err == nil {
+			t.Errorf("%q: expected error", d)
+		}
+	}
+}
+
+func TestRasterize(t *testing.T) {
+	m := rasterize([][]point{{{1, 1}, {3, 1}, {3, 3}, {1, 3}}}, image.Rect(0, 0, 4, 4))
+	if have, want := m.at(2, 2), float32(1); have != want {
+		t.Errorf("inside: have %v, want %v", have, want)
+	}
+	if have, want := m.at(0, 0), float32(0); have != want {
+		t.Errorf("outside: have %v, want %v", have, want)
+	}
+	h := rasterize([][]point{{{0, 0}, {1.5, 0}, {1.5, 1}, {0, 1}}}, image.Rect(0, 0, 4, 4))
+	if have, want := h.at(1, 0), float32(0.5); have < want-0.01 || have > want+0.01 {
+		t.Errorf("edge: have %v, want %v", have, want)
+	}
+}
+
+func TestSVG(t *testing.T) {
+	var buf bytes.Buffer
+	b := Board{Columns: 4}
+	for i := 0; i < 12; i++ {
+		b.Cards = append(b.Cards, Placed{X: i / 3, Y: i % 3, Card: 7 * i})
+	}
+	if err := WriteSVG(&buf, b, Classic); err != nil {
+		t.Fatal(err)
+	}
+	d := xml.NewDecoder(&buf)
+	for {
+		if _, err := d.Token(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPNG(t *testing.T) {
+	var buf bytes.Buffer
+	// one solid red diamond
+	if err := WritePNG(&buf, Single(0), Classic, 2); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := img.Bounds().Dx(), 2*cellWidth; have != want {
+		t.Errorf("width: have %v, want %v", have, want)
+	}
+	if have, want := img.Bounds().Dy(), 2*cellHeight; have != want {
+		t.Errorf("height: have %v, want %v", have, want)
+	}
+	at := func(x, y int) color.RGBA {
+		return color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+	}
+	if have, want := at(cellWidth, cellHeight), Classic.Symbols[0]; have != want {
+		t.Errorf("center: have %v, want %v", have, want)
+	}
+	if have, want := at(cellWidth, cellHeight-30), Classic.Background; have != want {
+		t.Errorf("card: have %v, want %v", have, want)
+	}
+	if have, want := at(1, 1), Classic.Table; have != want {
+		t.Errorf("table: have %v, want %v", have, want)
+	}
+}
+
+func TestInvalidCard(t *testing.T) {
+	if err := WriteSVG(io.Discard, Single(81), Classic); err == nil {
+		t.Error("expected error")
+	}
+	if _, err := Image(Single(-1), Classic, 1); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestImageTooLarge(t *testing.T) {
+	b := Board{Cards: []Placed{{X: 1000, Y: 0, Card: 0}}}
+	if _, err := Image(b, Classic, 8); err == nil {
+		t.Error("expected error")
+	}
+	if _, err := Image(Board{Columns: 27}, Classic, 8); err == nil {
+		t.Error("expected error")
+	}
+	if _, err := Image(Board{Columns: 27}, Classic, 2); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLabel(t *testing.T) {
+	if d, err := labelPath("12"); err != nil || d == "" {
+		t.Errorf("have %q, %v", d, err)
+	}
+	b := Single(0)
+	b.Cards[0].Label = "1a"
+	if err := WriteSVG(io.Discard, b, Classic); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Rooms can also be joined where websockets don't get through:
+// updates come as server-sent events on /api/events, in the JSON
+// format, and commands are posted to /api/command with the token
+// from the first event.
+
+// eventConn is a room connection over server-sent events.
+type eventConn struct {
+	room     *Room
+	clientId int
+	cmds     chan<- *cmd
+	done     chan struct{} // closed when the event stream ends
+}
+
+// command passes a command on to the room, or returns
+// false if the connection has ended.
+func (ec *eventConn) command(c Command) bool {
+	select {
+	case ec.cmds <- &cmd{clientId: ec.clientId, command: c}:
+		return true
+	case <-ec.done:
+	case <-ec.room.quit:
+	}
+	return false
+}
+
+// eventBuffer is how many events may wait for a slow client.
+const eventBuffer = 64
+
+// connKey is the request context key for the client's connection.
+type connKey struct{}
+
+// withConn is an http.Server ConnContext that keeps the connection
+// around, so that event streams can set write deadlines.
+func withConn(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// rawConn is the connection a request came in on, if the
+// server kept it.
+func rawConn(req *http.Request) net.Conn {
+	c, _ := req.Context().Value(connKey{}).(net.Conn)
+	return c
+}
+
+func newToken() string {
+	bs := make([]byte, 16)
+	if _, err := rand.Read(bs); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(bs)
+}
+
+func (rs *Rooms) ServeEvents(game, room, name, key, team string, w http.ResponseWriter, req *http.Request) {
+	ip := clientIP(req)
+	if !rs.admit(ip) {
+		http.Error(w, "too many connections", http.StatusTooManyRequests)
+		return
+	}
+	defer rs.leave(ip)
+	r := rs.get(game, room)
+	if r == nil {
+		http.Error(w, "too many rooms", http.StatusServiceUnavailable)
+		return
+	}
+	r.ServeEvents(rs, name, key, team, w, req)
+	rs.release(game, room, r)
+}
+
+// connection finds an event stream by its token.
+func (rs *Rooms) connection(token string) *eventConn {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.events[token]
+}
+
+// ServeEvents is Serve for server-sent events. Numbered updates
+// get event IDs like <stream>.<seq>, so browsers reconnecting
+// with Last-Event-ID pick up where they left off.
+func (r *Room) ServeEvents(rs *Rooms, name, key, team string, w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	proto, _ := parseProtocol(req)
+	s := proto.session(req)
+	if last := req.Header.Get("Last-Event-ID"); s.numbered && last != "" {
+		if i := strings.LastIndex(last, "."); i >= 0 {
+			s.stream = last[:i]
+			s.resume, _ = strconv.Atoi(last[i+1:])
+		}
+	}
+
+	updates, cmds, getId := r.connectSession(name, key, team, s)
+	ec := &eventConn{room: r, clientId: <-getId, cmds: cmds, done: make(chan struct{})}
+	token := newToken()
+	rs.mu.Lock()
+	rs.events[token] = ec
+	rs.mu.Unlock()
+	defer func() {
+		rs.mu.Lock()
+		delete(rs.events, token)
+		rs.mu.Unlock()
+	}()
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no") // for nginx
+
+	// Writing is left to writeEvents, so that a slow client can't
+	// hold up the room. If it falls too far behind, we drop it.
+	out := make(chan []byte, eventBuffer)
+	errs := make(chan error, 1)
+	go writeEvents(w, flusher, rawConn(req), out, ec.done, errs)
+	defer func() {
+		// w is the writer's until it is done
+		for range errs {
+		}
+	}()
+	failed := (<-chan error)(errs)
+	out <- []byte(fmt.Sprintf("event: connection\ndata: {\"token\":%q}\n\n", token))
+
+	var ping <-chan time.Time
+	if conf.Rooms.PingInterval.Duration > 0 {
+		t := time.NewTicker(conf.Rooms.PingInterval.Duration)
+		defer t.Stop()
+		ping = t.C
+	}
+	// Once the client is gone, we tell the room and keep
+	// taking updates until it lets go of us.
+	gone := req.Context().Done()
+	leave := func() {
+		gone, ping = nil, nil
+		close(ec.done)
+		close(out)
+		go func() {
+			select {
+			case cmds <- &cmd{clientId: ec.clientId, command: CmdDisconnect{}}:
+			case <-r.quit:
+			}
+		}()
+	}
+	send := func(bs []byte) {
+		select {
+		case out <- bs:
+		default:
+			log.Printf("event stream to %s fell behind", name)
+			leave()
+		}
+	}
+	for {
+		select {
+		case u, ok := <-updates:
+			if !ok {
+				log.Print("left room")
+				if gone != nil {
+					// let the last events go out first
+					close(out)
+					for range errs {
+					}
+					close(ec.done)
+				}
+				return
+			}
+			if gone == nil || !proto.wants(u) {
+				break
+			}
+			bs, err := encodeEvent(r.stream, u)
+			if err != nil {
+				log.Printf("event stream to %s failed: %s", name, err)
+				leave()
+				break
+			}
+			send(bs)
+		case <-ping:
+			send([]byte(": ping\n\n"))
+		case err := <-failed:
+			failed = nil
+			if gone != nil {
+				log.Printf("event stream to %s failed: %s", name, err)
+				leave()
+			}
+		case <-gone:
+			log.Printf("event stream to %s closed", name)
+			leave()
+		}
+	}
+}
+
+// writeEvents writes events to the client until out is closed or
+// done, or until a write fails or takes longer than the write
+// timeout. Flush doesn't return errors, but a failed flush cancels
+// the request, which ends the stream too.
+func writeEvents(w io.Writer, flusher http.Flusher, conn net.Conn, out <-chan []byte, done <-chan struct{}, failed chan<- error) {
+	defer close(failed)
+	for bs := range out {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if conn != nil {
+			conn.SetWriteDeadline(time.Now().Add(conf.Rooms.WriteTimeout.Duration))
+		}
+		if _, err := w.Write(bs); err != nil {
+			failed <- err
+			return
+		}
+		flusher.Flush()
+		if conn != nil {
+			conn.SetWriteDeadline(time.Time{})
+		}
+	}
+}
+
+func encodeEvent(stream string, u Update) ([]byte, error) {
+	var buf bytes.Buffer
+	if s, ok := u.(Sequenced); ok {
+		fmt.Fprintf(&buf, "id: %s.%d\n", stream, s.Seq)
+	}
+	buf.WriteString("data: ")
+	if err := (jsonCodec{}).encode(&buf, u); err != nil {
+		return nil, err
+	}
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+func eventsHandler(rooms *Rooms) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		game, room, name, ok := joinParams(w, r)
+		if !ok {
+			return
+		}
+		if _, err := parseProtocol(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := rooms.allow(game, room, r.FormValue("password"), r.FormValue("invite"), clientIP(r), time.Now()); err != nil {
+			refuseHTTP(w, err)
+			return
+		}
+		rooms.ServeEvents(game, room, name, r.FormValue("key"), r.FormValue("team"), w, r)
+	}
+}
+
+// commandHandler takes a command in the JSON format for
+// the event stream with the given token.
+func commandHandler(rooms *Rooms) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		ec := rooms.connection(r.FormValue("token"))
+		if ec == nil {
+			http.Error(w, "no such connection", http.StatusNotFound)
+			return
+		}
+		body := r.Body
+		if max := conf.Rooms.MaxMessageSize; max > 0 {
+			body = http.MaxBytesReader(w, body, int64(max))
+		}
+		c, err := jsonCodec{}.decode(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !ec.command(c) {
+			http.Error(w, "no such connection", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
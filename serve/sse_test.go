@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestEvents(t *testing.T) {
+	rooms := newRooms(nil, nil)
+	defer waitEmpty(rooms)
+	r := httprouter.New()
+	r.GET("/api/events", eventsHandler(rooms))
+	r.POST("/api/command", commandHandler(rooms))
+	s := httptest.NewServer(r)
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "/api/events?game=triplesmulti&room=sse&name=ann&caps=chat,resume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content type: have %s", ct)
+	}
+	lines := bufio.NewScanner(resp.Body)
+	// next returns the next event's type, ID and data
+	next := func() (string, string, string) {
+		var event, id, data string
+		for lines.Scan() {
+			l := lines.Text()
+			switch {
+			case l == "" && data != "":
+				return event, id, data
+			case strings.HasPrefix(l, "event: "):
+				event = strings.TrimPrefix(l, "event: ")
+			case strings.HasPrefix(l, "id: "):
+				id = strings.TrimPrefix(l, "id: ")
+			case strings.HasPrefix(l, "data: "):
+				data = strings.TrimPrefix(l, "data: ")
+			}
+		}
+		t.Fatalf("stream ended: %v", lines.Err())
+		return "", "", ""
+	}
+
+	event, _, data := next()
+	var conn struct{ Token string }
+	if err := json.Unmarshal([]byte(data), &conn); event != "connection" || err != nil || conn.Token == "" {
+		t.Fatalf("have %s %s", event, data)
+	}
+	if _, _, data := next(); !strings.HasPrefix(data, `{"type":"hello"`) {
+		t.Errorf("hello: have %s", data)
+	}
+	if _, id, data := next(); !strings.HasPrefix(data, `{"type":"full"`) || !strings.HasSuffix(id, ".0") {
+		t.Errorf("full: have %s %s", id, data)
+	}
+
+	post := func(token, body string) int {
+		resp, err := http.Post(s.URL+"/api/command?token="+token, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if have := post("nope", `{"type": "chat", "value": {"text": "hi"}}`); have != http.StatusNotFound {
+		t.Errorf("bad token: have %d", have)
+	}
+	if have := post(conn.Token, `{"type": "cheat"}`); have != http.StatusBadRequest {
+		t.Errorf("bad command: have %d", have)
+	}
+	if have := post(conn.Token, `{"type": "chat", "value": {"text": "hi"}}`); have != http.StatusNoContent {
+		t.Fatalf("chat: have %d", have)
+	}
+	for {
+		_, _, data := next()
+		if strings.HasPrefix(data, `{"type":"eventChat"`) {
+			break
+		}
+	}
+}
+
+// stuckWriter is a client that stops reading.
+type stuckWriter struct {
+	*httptest.ResponseRecorder
+	release chan struct{}
+}
+
+func (w stuckWriter) Write(bs []byte) (int, error) {
+	<-w.release
+	return len(bs), nil
+}
+
+func TestEventsFallBehind(t *testing.T) {
+	defer func(c RoomConfig) { conf.Rooms = c }(conf.Rooms)
+	conf.Rooms.PingInterval = Duration{time.Millisecond}
+	rooms := newRooms(nil, nil)
+	defer waitEmpty(rooms)
+
+	w := stuckWriter{httptest.NewRecorder(), make(chan struct{})}
+	done := make(chan struct{})
+	go func() {
+		rooms.ServeEvents("triplesmulti", "slow", "ann", "", "", w, httptest.NewRequest("GET", "/api/events", nil))
+		close(done)
+	}()
+	// the room carries on, and lets ann go once her events pile up
+	joined := false
+	for start := time.Now(); ; {
+		if time.Since(start) > 5*time.Second {
+			close(w.release)
+			t.Fatal("ann is still there")
+		}
+		if rm := rooms.find("triplesmulti", "slow"); rm != nil {
+			f, ok := rm.full()
+			if !ok {
+				t.Fatal("room closed")
+			}
+			p, ok := f.Players["ann"]
+			if ok && p.Present {
+				joined = true
+			} else if joined {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(w.release)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream didn't end")
+	}
+}
+
+func TestWriteEventsTimeout(t *testing.T) {
+	defer func(c RoomConfig) { conf.Rooms = c }(conf.Rooms)
+	conf.Rooms.WriteTimeout = Duration{10 * time.Millisecond}
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+
+	out := make(chan []byte, 1)
+	out <- []byte(": ping\n\n")
+	failed := make(chan error, 1)
+	go writeEvents(conn, httptest.NewRecorder(), conn, out, make(chan struct{}), failed)
+	select {
+	case err := <-failed:
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			t.Errorf("have %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("write didn't time out")
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"html"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// The client, built into static/ by the Makefile.
+//
+//go:embed static
+var embedded embed.FS
+
+const (
+	hashLength     = 12
+	longCache      = "public, max-age=31536000, immutable"
+	revalidate     = "no-cache"
+	indexName      = "index.html"
+	minCompression = 512 // not worth compressing below this
+)
+
+// compressible are the file types worth compressing.
+var compressible = map[string]bool{
+	".html": true,
+	".css":  true,
+	".js":   true,
+	".svg":  true,
+	".json": true,
+}
+
+// Assets are the client's files. Every file can also be fetched
+// under a name with a hash of its contents, like main.0123456789ab.js,
+// which index.html links to and which may be cached forever.
+//
+// Embedded assets are loaded once, and come with gzip and brotli
+// variants if the build left foo.js.gz or foo.js.br next to foo.js;
+// gzip variants are made up otherwise. Assets from a directory on
+// disk are read on every request and not compressed, for development.
+type Assets struct {
+	fsys  fs.FS
+	base  string // the path prefix, for index.html
+	cache bool
+	mu    sync.Mutex
+	files map[string]*asset
+}
+
+type asset struct {
+	name   string
+	data   []byte
+	hash   string
+	gzip   []byte
+	brotli []byte
+}
+
+func embeddedAssets(base string) *Assets {
+	sub, err := fs.Sub(embedded, "static")
+	if err != nil {
+		panic(err)
+	}
+	return &Assets{fsys: sub, base: base, cache: true, files: map[string]*asset{}}
+}
+
+func diskAssets(dir, base string) *Assets {
+	return &Assets{fsys: os.DirFS(dir), base: base, files: map[string]*asset{}}
+}
+
+// get loads a file, with the links in index.html pointing
+// to the hashed names.
+func (a *Assets) get(name string) (*asset, error) {
+	if a.cache {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if f, ok := a.files[name]; ok {
+			return f, nil
+		}
+	}
+	data, err := fs.ReadFile(a.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	if name == indexName {
+		if data, err = a.link(data); err != nil {
+			return nil, err
+		}
+	}
+	f := &asset{name: name, data: data, hash: contentHash(data)}
+	if a.cache {
+		f.gzip, _ = fs.ReadFile(a.fsys, name+".gz")
+		f.brotli, _ = fs.ReadFile(a.fsys, name+".br")
+		if f.gzip == nil && compressible[path.Ext(name)] && len(data) >= minCompression {
+			f.gzip = compress(data)
+		}
+		a.files[name] = f
+	}
+	return f, nil
+}
+
+// link points index.html's references to the other assets at
+// their hashed names, and tells the page where the app lives.
+func (a *Assets) link(index []byte) ([]byte, error) {
+	s := strings.Replace(string(index), "<head>", `<head>
+<base href="`+html.EscapeString(a.base)+`/">`, 1)
+	err := fs.WalkDir(a.fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || p == indexName {
+			return err
+		}
+		if ext := path.Ext(p); ext == ".gz" || ext == ".br" {
+			return nil
+		}
+		data, err := fs.ReadFile(a.fsys, p)
+		if err != nil {
+			return err
+		}
+		s = strings.ReplaceAll(s, `"static/`+p+`"`, `"static/`+hashedName(p, contentHash(data))+`"`)
+		return nil
+	})
+	return []byte(s), err
+}
+
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:hashLength]
+}
+
+// hashedName turns dir/main.js into dir/main.<hash>.js.
+func hashedName(name, hash string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash + ext
+}
+
+// unhash undoes hashedName, returning the hash if there is one.
+func unhash(name string) (string, string) {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	hash := path.Ext(base)
+	if len(hash) != hashLength+1 {
+		return name, ""
+	}
+	if _, err := hex.DecodeString(hash[1:]); err != nil {
+		return name, ""
+	}
+	return strings.TrimSuffix(base, hash) + ext, hash[1:]
+}
+
+func compress(data []byte) []byte {
+	var buf bytes.Buffer
+	w, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+// accepts tells whether an Accept-Encoding header allows an encoding.
+func accepts(header, encoding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		if strings.TrimSpace(fields[0]) != encoding {
+			continue
+		}
+		for _, p := range fields[1:] {
+			p = strings.TrimSpace(p)
+			if !strings.HasPrefix(p, "q=") {
+				continue
+			}
+			if q, err := strconv.ParseFloat(p[2:], 64); err == nil && q == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func (f *asset) serve(w http.ResponseWriter, r *http.Request, cache string) {
+	h := w.Header()
+	data, etag := f.data, f.hash
+	if f.brotli != nil || f.gzip != nil {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	ae := r.Header.Get("Accept-Encoding")
+	if f.brotli != nil && accepts(ae, "br") {
+		data, etag = f.brotli, etag+"-br"
+		h.Set("Content-Encoding", "br")
+	} else if f.gzip != nil && accepts(ae, "gzip") {
+		data, etag = f.gzip, etag+"-gz"
+		h.Set("Content-Encoding", "gzip")
+	}
+	h.Set("Cache-Control", cache)
+	h.Set("ETag", `"`+etag+`"`)
+	if ct := mime.TypeByExtension(path.Ext(f.name)); ct != "" {
+		h.Set("Content-Type", ct)
+	}
+	http.ServeContent(w, r, f.name, time.Time{}, bytes.NewReader(data))
+}
+
+func indexHandler(a *Assets) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		f, err := a.get(indexName)
+		if err != nil {
+			http.Error(w, "no client", http.StatusNotFound)
+			return
+		}
+		f.serve(w, r, revalidate)
+	}
+}
+
+// staticHandler serves assets by their plain or hashed names. Only
+// the current hash gets cached for good; old hashes get the current
+// file, which beats a broken page.
+func staticHandler(a *Assets) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		name, hash := unhash(strings.TrimPrefix(ps.ByName("filepath"), "/"))
+		if !fs.ValidPath(name) || path.Ext(name) == ".gz" || path.Ext(name) == ".br" {
+			http.NotFound(w, r)
+			return
+		}
+		f, err := a.get(name)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		cache := revalidate
+		if hash != "" && hash == f.hash {
+			cache = longCache
+		}
+		f.serve(w, r, cache)
+	}
+}
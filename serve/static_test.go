@@ -0,0 +1,103 @@
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestAssets(t *testing.T) {
+	js := strings.Repeat("console.log('triples');\n", 100)
+	a := &Assets{
+		fsys: fstest.MapFS{
+			"index.html": {Data: []byte(`<head><script src="static/main.js"></script>`)},
+			"main.js":    {Data: []byte(js)},
+			"main.js.br": {Data: []byte("brotli")},
+			"style.css":  {Data: []byte("body {}")},
+		},
+		base:  "/triples",
+		cache: true,
+		files: map[string]*asset{},
+	}
+	r := httprouter.New()
+	r.GET("/", indexHandler(a))
+	r.GET("/static/*filepath", staticHandler(a))
+	get := func(path string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	hashed := "main." + contentHash([]byte(js)) + ".js"
+	w := get("/")
+	if have, want := w.Body.String(), `<head>
+<base href="/triples/"><script src="static/`+hashed+`"></script>`; have != want {
+		t.Errorf("index: have %s, want %s", have, want)
+	}
+	if have := w.Header().Get("Cache-Control"); have != revalidate {
+		t.Errorf("index cache: have %s", have)
+	}
+
+	w = get("/static/" + hashed)
+	if have := w.Header().Get("Cache-Control"); have != longCache {
+		t.Errorf("hashed cache: have %s", have)
+	}
+	if have := w.Body.String(); have != js {
+		t.Errorf("hashed body: have %.20s...", have)
+	}
+	etag := w.Header().Get("ETag")
+	if w := get("/static/main.js", "If-None-Match", etag); w.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match: have %d, want %d", w.Code, http.StatusNotModified)
+	}
+	if have := get("/static/main.000000000000.js").Header().Get("Cache-Control"); have != revalidate {
+		t.Errorf("stale hash cache: have %s", have)
+	}
+
+	w = get("/static/main.js", "Accept-Encoding", "gzip, br")
+	if have := w.Header().Get("Content-Encoding"); have != "br" || w.Body.String() != "brotli" {
+		t.Errorf("brotli: have %q %q", have, w.Body.String())
+	}
+	w = get("/static/main.js", "Accept-Encoding", "gzip, br;q=0")
+	if have := w.Header().Get("Content-Encoding"); have != "gzip" {
+		t.Fatalf("gzip: have %q", have)
+	}
+	if w.Header().Get("ETag") == etag {
+		t.Errorf("gzip variant has the same ETag")
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bs, _ := ioutil.ReadAll(zr); string(bs) != js {
+		t.Errorf("gzip body: have %.20s...", bs)
+	}
+	if have := get("/static/style.css", "Accept-Encoding", "gzip").Header().Get("Content-Encoding"); have != "" {
+		t.Errorf("small file compressed: %s", have)
+	}
+
+	for _, p := range []string{"/static/main.js.br", "/static/nothing.js", "/static/../main.go"} {
+		if w := get(p); w.Code != http.StatusNotFound {
+			t.Errorf("%s: have %d, want %d", p, w.Code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestEmbeddedAssets(t *testing.T) {
+	f, err := embeddedAssets("").get(indexName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(f.data), `"static/style.`) || strings.Contains(string(f.data), `"static/style.css"`) {
+		t.Errorf("index.html doesn't link to the hashed style.css")
+	}
+}
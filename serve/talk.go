@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	maxChatLength = 200
+	chatHistory   = 20
+	talkBurst     = 5
+	talkWindow    = 10 * time.Second
+)
+
+// reactions are the quick reactions players can send.
+var reactions = map[string]bool{
+	"👍": true,
+	"👏": true,
+	"😮": true,
+	"😂": true,
+	"😢": true,
+	"🔥": true,
+}
+
+// ChatLine is a chat message in a room.
+type ChatLine struct {
+	Name string `json:"name"`
+	Text string `json:"text"`
+}
+
+// cleanChat tidies up a chat message, returning false
+// if there's nothing left to send.
+func cleanChat(text string) (string, bool) {
+	text = strings.TrimSpace(text)
+	if r := []rune(text); len(r) > maxChatLength {
+		text = string(r[:maxChatLength])
+	}
+	return text, text != ""
+}
+
+// rateLimit limits how often each player may do something: at
+// most burst times in any window. A zero burst means no limit.
+type rateLimit struct {
+	burst  int
+	window time.Duration
+	recent map[string][]time.Time
+	pruned time.Time // when we last forgot quiet names
+}
+
+func newRateLimit(burst int, window time.Duration) *rateLimit {
+	return &rateLimit{burst: burst, window: window, recent: map[string][]time.Time{}}
+}
+
+func (l *rateLimit) allow(name string, now time.Time) bool {
+	if l.burst <= 0 {
+		return true
+	}
+	if now.Sub(l.pruned) >= l.window {
+		for n, ts := range l.recent {
+			if len(ts) == 0 || now.Sub(ts[len(ts)-1]) >= l.window {
+				delete(l.recent, n)
+			}
+		}
+		l.pruned = now
+	}
+	var ts []time.Time
+	for _, t := range l.recent[name] {
+		if now.Sub(t) < l.window {
+			ts = append(ts, t)
+		}
+	}
+	if len(ts) >= l.burst {
+		l.recent[name] = ts
+		return false
+	}
+	l.recent[name] = append(ts, now)
+	return true
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTalkLimit(t *testing.T) {
+	l := newRateLimit(talkBurst, talkWindow)
+	now := time.Now()
+	for i := 0; i < talkBurst; i++ {
+		if !l.allow("Ann", now) {
+			t.Fatalf("message %d not allowed", i)
+		}
+	}
+	if l.allow("Ann", now.Add(time.Second)) {
+		t.Error("too many messages allowed")
+	}
+	if !l.allow("Bob", now) {
+		t.Error("Bob is limited by Ann")
+	}
+	if !l.allow("Ann", now.Add(talkWindow)) {
+		t.Error("still limited after the window")
+	}
+	l.allow("Ann", now.Add(3*talkWindow))
+	if have, want := len(l.recent), 1; have != want {
+		t.Errorf("remembered %v names, want %v", have, want)
+	}
+}
+
+func TestCleanChat(t *testing.T) {
+	if _, ok := cleanChat("  \n"); ok {
+		t.Error("empty message accepted")
+	}
+	long, _ := cleanChat(strings.Repeat("ä", 2*maxChatLength))
+	if have, want := len([]rune(long)), maxChatLength; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+}
+
+func TestRoomChat(t *testing.T) {
+	r := newRoom("triplesmulti", "chat", nil, nil)
+	defer r.close()
+	ann, send := joinRoom(r, "Ann", "")
+	send(CmdChat{Text: " good morning "})
+	waitFor(t, ann, func(u Update) bool {
+		e, ok := u.(EventChat)
+		return ok && e.Text == "good morning"
+	})
+	send(CmdReact{Reaction: "🔥"})
+	waitFor(t, ann, func(u Update) bool {
+		_, ok := u.(EventReact)
+		return ok
+	})
+
+	bob, _ := joinRoom(r, "Bob", "")
+	waitFor(t, bob, func(u Update) bool {
+		f, ok := u.(Full)
+		if !ok {
+			return false
+		}
+		if want := []ChatLine{{Name: "Ann", Text: "good morning"}}; !reflect.DeepEqual(f.Chat, want) {
+			t.Errorf("have %v, want %v", f.Chat, want)
+		}
+		return true
+	})
+}
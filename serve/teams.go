@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const maxTeamName = 20
+
+// cleanTeam tidies up a team name picked by a player.
+func cleanTeam(team string) string {
+	team = strings.TrimSpace(team)
+	if r := []rune(team); len(r) > maxTeamName {
+		team = string(r[:maxTeamName])
+	}
+	return team
+}
+
+// assignTeam picks a team for a player who didn't pick one: the
+// smallest team among the players, or a new team if there are
+// fewer than two.
+func assignTeam(teams map[string]string, players map[string]struct{}) string {
+	sizes := map[string]int{}
+	for _, t := range teams {
+		sizes[t] += 0
+	}
+	for p := range players {
+		if t, ok := teams[p]; ok {
+			sizes[t]++
+		}
+	}
+	if len(sizes) < 2 {
+		for i := 1; ; i++ {
+			t := fmt.Sprintf("Team %d", i)
+			if _, ok := sizes[t]; !ok {
+				return t
+			}
+		}
+	}
+	var names []string
+	for t := range sizes {
+		names = append(names, t)
+	}
+	sort.Strings(names)
+	best := names[0]
+	for _, t := range names[1:] {
+		if sizes[t] < sizes[best] {
+			best = t
+		}
+	}
+	return best
+}
+
+// teamScores adds up the players' scores by team.
+func teamScores(teams map[string]string, scores map[string]int) map[string]int {
+	ts := map[string]int{}
+	for p, t := range teams {
+		ts[t] += scores[p]
+	}
+	return ts
+}
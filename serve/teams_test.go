@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAssignTeam(t *testing.T) {
+	for _, tc := range []struct {
+		teams   map[string]string
+		players []string
+		want    string
+	}{
+		{map[string]string{}, nil, "Team 1"},
+		{map[string]string{"Ann": "Team 1"}, []string{"Ann"}, "Team 2"},
+		{map[string]string{"Ann": "Red", "Bob": "Red", "Eve": "Blue"}, []string{"Ann", "Bob", "Eve"}, "Blue"},
+		// players who left don't count
+		{map[string]string{"Ann": "Red", "Bob": "Red", "Eve": "Blue", "Tom": "Blue"}, []string{"Ann", "Bob", "Eve"}, "Blue"},
+	} {
+		ps := map[string]struct{}{}
+		for _, p := range tc.players {
+			ps[p] = struct{}{}
+		}
+		if have := assignTeam(tc.teams, ps); have != tc.want {
+			t.Errorf("%v: have %v, want %v", tc.teams, have, tc.want)
+		}
+	}
+}
+
+func TestTeamScores(t *testing.T) {
+	teams := map[string]string{"Ann": "Red", "Bob": "Red", "Eve": "Blue"}
+	scores := map[string]int{"Ann": 3, "Bob": -1, "Eve": 4, "Tom": 9}
+	want := map[string]int{"Red": 2, "Blue": 4}
+	if have := teamScores(teams, scores); !reflect.DeepEqual(have, want) {
+		t.Errorf("have %v, want %v", have, want)
+	}
+}
+
+func TestRoomTeams(t *testing.T) {
+	r := newRoom("triplesmulti", "teams", nil, nil)
+	defer r.close()
+	ann, send := joinRoom(r, "Ann", " Red ")
+	joinRoom(r, "Bob", "")
+	send(CmdStart{})
+	// the game's first snapshot
+	var f Full
+	waitFor(t, ann, func(u Update) bool {
+		var ok bool
+		f, ok = u.(Full)
+		return ok && f.DeckSize > 0
+	})
+	if have, want := f.Players["Ann"].Team, "Red"; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	if have, want := f.Players["Bob"].Team, "Team 1"; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	if have, want := len(f.Teams), 2; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"math/rand"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -14,7 +16,7 @@ import (
 	"golang.org/x/crypto/nacl/secretbox"
 )
 
-func startBot(token string) ScoreHandler {
+func startBot(token string, queue *ScoreQueue) (ScoreHandler, ResultHandler, Identifier) {
 	var (
 		blobKey   = genKey()
 		actions   = make(chan BotAction)
@@ -22,50 +24,93 @@ func startBot(token string) ScoreHandler {
 	)
 
 	for _, g := range games {
-		callbacks = append(callbacks, handleGame(g, *baseURL, blobKey))
+		callbacks = append(callbacks, handleGame(g, conf.Base, blobKey))
 	}
 	for _, g := range multigames {
-		callbacks = append(callbacks, handleMultiGame(g, *baseURL))
+		callbacks = append(callbacks, handleMultiGame(g, conf.Base, blobKey))
 	}
-	go runBot(token, callbacks, actions)
+	go runBot(token, callbacks, newChatGames(conf.Base), actions)
+	go queue.run(deliverScores(actions))
 
-	return handleScore(actions, blobKey)
+	return handleScore(queue, blobKey), handleResults(queue, actions, blobKey), identifyPlayer(blobKey)
+}
+
+// identifyPlayer identifies players by their Telegram user.
+func identifyPlayer(blobKey [32]byte) Identifier {
+	return func(key string) (string, bool) {
+		blob, err := decode(key, blobKey)
+		if err != nil || blob.UserID == 0 {
+			return "", false
+		}
+		return strconv.Itoa(blob.UserID), true
+	}
 }
 
 func runBot(
 	token string,
 	callbacks []CallbackHandler,
+	chats *ChatGames,
 	actions <-chan BotAction,
 ) {
-	bot, err := tgbotapi.NewBotAPI(token)
+	api, err := tgbotapi.NewBotAPI(token)
 	if err != nil {
 		log.Fatalf("creating bot: %s", err)
 	}
 
-	bot.Debug = *debugbot
+	api.Debug = conf.DebugBot
 
-	log.Printf("Authorized on account %s", bot.Self.UserName)
+	log.Printf("Authorized on account %s", api.Self.UserName)
 
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
+	serveBot(telegramClient{api}, callbacks, chats, actions)
+}
 
-	updates, err := bot.GetUpdatesChan(u)
+func serveBot(
+	bot BotClient,
+	callbacks []CallbackHandler,
+	chats *ChatGames,
+	actions <-chan BotAction,
+) {
+	updates, err := bot.Updates()
+	if err != nil {
+		log.Fatalf("getting updates: %s", err)
+	}
 
 	for {
 		select {
 		case update := <-updates:
-			handleUpdate(bot, callbacks, update)
+			handleUpdate(bot, callbacks, chats, update)
 		case action := <-actions:
 			action(bot)
 		}
 	}
 }
 
-type BotAction func(*tgbotapi.BotAPI)
+// BotClient is the part of the Telegram bot API that the bot uses.
+type BotClient interface {
+	Send(tgbotapi.Chattable) (tgbotapi.Message, error)
+	AnswerCallbackQuery(tgbotapi.CallbackConfig) (tgbotapi.APIResponse, error)
+	AnswerInlineQuery(tgbotapi.InlineConfig) (tgbotapi.APIResponse, error)
+	Updates() (<-chan tgbotapi.Update, error)
+}
+
+// telegramClient is the BotClient that talks to Telegram.
+type telegramClient struct {
+	*tgbotapi.BotAPI
+}
 
-func handleUpdate(bot *tgbotapi.BotAPI, callbacks []CallbackHandler, update tgbotapi.Update) {
+func (c telegramClient) Updates() (<-chan tgbotapi.Update, error) {
+	u := tgbotapi.NewUpdate(0)
+	u.Timeout = 60
+	return c.GetUpdatesChan(u)
+}
+
+type BotAction func(BotClient)
+
+func handleUpdate(bot BotClient, callbacks []CallbackHandler, chats *ChatGames, update tgbotapi.Update) {
 	if m := update.Message; m != nil {
-		if t := m.Text; len(t) > 0 && t[0] == '/' {
+		if chats != nil && chats.handle(bot, m) {
+			log.Printf("handled chat game message: %s", m.Text)
+		} else if t := m.Text; len(t) > 0 && t[0] == '/' {
 			words := strings.Fields(t)
 			if len(words) == 2 && words[0] == "/send" {
 				log.Printf("answering /send: %s", words[1])
@@ -138,7 +183,18 @@ type Blob struct {
 }
 
 func encode(b Blob, key [32]byte) string {
-	js, err := json.Marshal(b)
+	return seal(b, key)
+}
+
+func decode(s string, key [32]byte) (Blob, error) {
+	var b Blob
+	return b, unseal(s, key, &b)
+}
+
+// seal encrypts v as JSON, so that it can be handed out and
+// later trusted when it comes back.
+func seal(v interface{}, key [32]byte) string {
+	js, err := json.Marshal(v)
 	if err != nil {
 		panic(err)
 	}
@@ -153,21 +209,23 @@ func encode(b Blob, key [32]byte) string {
 	return base64.RawURLEncoding.EncodeToString(bs)
 }
 
-func decode(s string, key [32]byte) (Blob, error) {
+func unseal(s string, key [32]byte, v interface{}) error {
 	bs, err := base64.RawURLEncoding.DecodeString(s)
 	if err != nil {
-		return Blob{}, err
+		return err
 	}
 	var nonce [24]byte
+	if len(bs) < len(nonce) {
+		return fmt.Errorf("short blob")
+	}
 	copy(nonce[:], bs)
 	box := bs[24:]
 	var out []byte
 	js, ok := secretbox.Open(out, box, &nonce, &key)
 	if !ok {
-		return Blob{}, fmt.Errorf("bad blob")
+		return fmt.Errorf("bad blob")
 	}
-	var b Blob
-	return b, json.Unmarshal(js, &b)
+	return json.Unmarshal(js, v)
 }
 
 func genKey() [32]byte {
@@ -179,23 +237,28 @@ func genKey() [32]byte {
 	return key
 }
 
+func newBlob(shortname string, q *tgbotapi.CallbackQuery) Blob {
+	b := Blob{
+		Game:            shortname,
+		UserID:          q.From.ID,
+		FirstName:       q.From.FirstName,
+		InlineMessageID: q.InlineMessageID,
+		ChatInstance:    q.ChatInstance,
+	}
+	if msg := q.Message; msg != nil {
+		b.MessageID = msg.MessageID
+		b.ChatID = msg.Chat.ID
+	}
+	return b
+}
+
 func handleGame(shortname, u string, key [32]byte) CallbackHandler {
 	return func(q *tgbotapi.CallbackQuery) *tgbotapi.CallbackConfig {
 		if g := q.GameShortName; g != shortname {
 			return nil
 		}
 
-		b := Blob{
-			Game:            shortname,
-			UserID:          q.From.ID,
-			FirstName:       q.From.FirstName,
-			InlineMessageID: q.InlineMessageID,
-			ChatInstance:    q.ChatInstance,
-		}
-		if msg := q.Message; msg != nil {
-			b.MessageID = msg.MessageID
-			b.ChatID = msg.Chat.ID
-		}
+		b := newBlob(shortname, q)
 		key := encode(b, key)
 		var v = url.Values{}
 		v.Add("game", shortname)
@@ -205,12 +268,12 @@ func handleGame(shortname, u string, key [32]byte) CallbackHandler {
 		log.Printf("game callback: %s: %+v", shortname, b)
 		return &tgbotapi.CallbackConfig{
 			CallbackQueryID: q.ID,
-			URL:             u + "?" + v.Encode(),
+			URL:             u + "/?" + v.Encode(),
 		}
 	}
 }
 
-func handleMultiGame(shortname, u string) CallbackHandler {
+func handleMultiGame(shortname, u string, key [32]byte) CallbackHandler {
 	return func(q *tgbotapi.CallbackQuery) *tgbotapi.CallbackConfig {
 		if g := q.GameShortName; g != shortname {
 			return nil
@@ -218,19 +281,21 @@ func handleMultiGame(shortname, u string) CallbackHandler {
 
 		room := base64.RawURLEncoding.EncodeToString([]byte(q.ChatInstance))
 
+		b := newBlob(shortname, q)
 		var v = url.Values{}
 		v.Add("game", shortname)
 		v.Add("room", room)
+		v.Add("key", encode(b, key))
 		v.Add("name", q.From.FirstName)
-		log.Printf("multi game callback: %s", shortname)
+		log.Printf("multi game callback: %s: %+v", shortname, b)
 		return &tgbotapi.CallbackConfig{
 			CallbackQueryID: q.ID,
-			URL:             u + "?" + v.Encode(),
+			URL:             u + "/?" + v.Encode(),
 		}
 	}
 }
 
-func sendScore(blob Blob, score int) BotAction {
+func sendScore(bot BotClient, blob Blob, score int) error {
 	sc := tgbotapi.SetGameScoreConfig{
 		UserID:          blob.UserID,
 		Score:           score,
@@ -238,24 +303,106 @@ func sendScore(blob Blob, score int) BotAction {
 		MessageID:       blob.MessageID,
 		InlineMessageID: blob.InlineMessageID,
 	}
-	return func(bot *tgbotapi.BotAPI) {
-		if _, err := bot.Send(sc); err != nil {
-			log.Printf("send score %s=%d: %s", blob.FirstName, score, err)
-		} else {
-			log.Printf("sent score %s=%d", blob.FirstName, score)
+	_, err := bot.Send(sc)
+	var apiErr tgbotapi.Error
+	if !errors.As(err, &apiErr) || apiErr.RetryAfter > 0 {
+		// transport trouble or flood control, worth another try
+		return err
+	}
+	if strings.Contains(apiErr.Message, "BOT_SCORE_NOT_MODIFIED") {
+		// the player did better before, which is fine
+		return nil
+	}
+	return fmt.Errorf("%w: %s", errRejected, err)
+}
+
+// deliverScores sends scores from the bot goroutine,
+// waiting for the result.
+func deliverScores(actions chan<- BotAction) func(Blob, int) error {
+	return func(blob Blob, score int) error {
+		done := make(chan error, 1)
+		actions <- func(bot BotClient) {
+			done <- sendScore(bot, blob, score)
 		}
+		return <-done
 	}
 }
 
 type ScoreHandler func(string, int) error
 
-func handleScore(actions chan<- BotAction, blobKey [32]byte) ScoreHandler {
+var errBadKey = errors.New("bad key")
+
+func handleScore(queue *ScoreQueue, blobKey [32]byte) ScoreHandler {
 	return func(key string, score int) error {
 		blob, err := decode(key, blobKey)
 		if err != nil {
-			return fmt.Errorf("decoding blob %q: %s", blob, err)
+			return fmt.Errorf("%w: decoding blob: %s", errBadKey, err)
 		}
-		actions <- sendScore(blob, score)
-		return nil
+		return queue.push(blob, score)
+	}
+}
+
+// ResultHandler receives the final scores of a multiplayer game,
+// together with the keys that players joined the room with.
+type ResultHandler func(keys map[string]string, scores map[string]int)
+
+func handleResults(queue *ScoreQueue, actions chan<- BotAction, blobKey [32]byte) ResultHandler {
+	return func(keys map[string]string, scores map[string]int) {
+		chats := map[int64]bool{}
+		for name, key := range keys {
+			blob, err := decode(key, blobKey)
+			if err != nil {
+				log.Printf("decoding blob for %s: %s", name, err)
+				continue
+			}
+			if blob.ChatID != 0 {
+				chats[blob.ChatID] = true
+			}
+			if score := scores[name]; score < 0 {
+				log.Printf("not sending negative score %s=%d", name, score)
+			} else if err := queue.push(blob, score); err != nil {
+				log.Printf("queueing score %s=%d: %s", name, score, err)
+			}
+		}
+		if len(chats) == 0 {
+			return
+		}
+		text := formatResults(scores)
+		for chatID := range chats {
+			actions <- sendMessage(chatID, text)
+		}
+	}
+}
+
+func sendMessage(chatID int64, text string) BotAction {
+	msg := tgbotapi.NewMessage(chatID, text)
+	return func(bot BotClient) {
+		if _, err := bot.Send(msg); err != nil {
+			log.Printf("send message to %d: %s", chatID, err)
+		}
+	}
+}
+
+// formatResults announces the final scores.
+func formatResults(scores map[string]int) string {
+	return "Game over!\n" + formatScores(scores)
+}
+
+// formatScores lists players by score, best first.
+func formatScores(scores map[string]int) string {
+	var names []string
+	for name := range scores {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if scores[names[i]] != scores[names[j]] {
+			return scores[names[i]] > scores[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	var lines []string
+	for i, name := range names {
+		lines = append(lines, fmt.Sprintf("%d. %s: %d", i+1, name, scores[name]))
 	}
+	return strings.Join(lines, "\n")
 }
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/robx/telegram-bot-api"
+)
+
+// fakeBot is an in-memory BotClient that records what is sent.
+type fakeBot struct {
+	mu        sync.Mutex
+	sent      []tgbotapi.Chattable
+	callbacks []tgbotapi.CallbackConfig
+	inline    []tgbotapi.InlineConfig
+	sendErr   error
+	updates   chan tgbotapi.Update
+}
+
+func newFakeBot() *fakeBot {
+	return &fakeBot{
+		updates: make(chan tgbotapi.Update),
+	}
+}
+
+func (b *fakeBot) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.sendErr != nil {
+		return tgbotapi.Message{}, b.sendErr
+	}
+	b.sent = append(b.sent, c)
+	return tgbotapi.Message{MessageID: len(b.sent)}, nil
+}
+
+func (b *fakeBot) AnswerCallbackQuery(c tgbotapi.CallbackConfig) (tgbotapi.APIResponse, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.callbacks = append(b.callbacks, c)
+	return tgbotapi.APIResponse{Ok: true}, nil
+}
+
+func (b *fakeBot) AnswerInlineQuery(c tgbotapi.InlineConfig) (tgbotapi.APIResponse, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.inline = append(b.inline, c)
+	return tgbotapi.APIResponse{Ok: true}, nil
+}
+
+func (b *fakeBot) Updates() (<-chan tgbotapi.Update, error) {
+	return b.updates, nil
+}
+
+func (b *fakeBot) Sent() []tgbotapi.Chattable {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]tgbotapi.Chattable(nil), b.sent...)
+}
+
+func command(chatID int64, text string) tgbotapi.Update {
+	return tgbotapi.Update{
+		Message: &tgbotapi.Message{
+			Chat: &tgbotapi.Chat{ID: chatID},
+			Text: text,
+		},
+	}
+}
+
+func TestSendCommand(t *testing.T) {
+	bot := newFakeBot()
+	handleUpdate(bot, nil, nil, command(42, "/send quadruples"))
+	handleUpdate(bot, nil, nil, command(42, "/send chess"))
+	handleUpdate(bot, nil, nil, command(42, "not a command"))
+
+	sent := bot.Sent()
+	if have, want := len(sent), 2; have != want {
+		t.Fatalf("have %v, want %v", have, want)
+	}
+	if g, ok := sent[0].(tgbotapi.GameConfig); !ok {
+		t.Errorf("have %T, want GameConfig", sent[0])
+	} else if g.GameShortName != "quadruples" || g.ChatID != 42 {
+		t.Errorf("have %+v", g)
+	}
+	if m, ok := sent[1].(tgbotapi.MessageConfig); !ok {
+		t.Errorf("have %T, want MessageConfig", sent[1])
+	} else if m.Text != "I don't know that game" {
+		t.Errorf("have %q", m.Text)
+	}
+}
+
+func TestInlineQuery(t *testing.T) {
+	bot := newFakeBot()
+	handleUpdate(bot, nil, nil, tgbotapi.Update{
+		InlineQuery: &tgbotapi.InlineQuery{ID: "q"},
+	})
+	if have, want := len(bot.inline), 1; have != want {
+		t.Fatalf("have %v, want %v", have, want)
+	}
+	if have, want := len(bot.inline[0].Results), len(games); have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+}
+
+func callbackQuery(game string) tgbotapi.Update {
+	return tgbotapi.Update{
+		CallbackQuery: &tgbotapi.CallbackQuery{
+			ID:            "cb",
+			From:          &tgbotapi.User{ID: 7, FirstName: "Ann"},
+			ChatInstance:  "instance",
+			GameShortName: game,
+			Message: &tgbotapi.Message{
+				MessageID: 3,
+				Chat:      &tgbotapi.Chat{ID: 42},
+			},
+		},
+	}
+}
+
+func TestGameCallback(t *testing.T) {
+	var (
+		bot = newFakeBot()
+		key = genKey()
+		cbs = []CallbackHandler{
+			handleGame("triples", "https://example.com/triples", key),
+			handleMultiGame("triplesmulti", "https://example.com/triples", key),
+		}
+	)
+	handleUpdate(bot, cbs, nil, callbackQuery("triples"))
+	handleUpdate(bot, cbs, nil, callbackQuery("triplesmulti"))
+	handleUpdate(bot, cbs, nil, callbackQuery("unknown"))
+	if have, want := len(bot.callbacks), 2; have != want {
+		t.Fatalf("have %v, want %v", have, want)
+	}
+
+	u, err := url.Parse(bot.callbacks[0].URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := u.Host, "example.com"; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	q := u.Query()
+	if have, want := q.Get("game"), "triples"; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	b, err := decode(q.Get("key"), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Blob{
+		Game:         "triples",
+		UserID:       7,
+		FirstName:    "Ann",
+		ChatInstance: "instance",
+		ChatID:       42,
+		MessageID:    3,
+	}
+	if b != want {
+		t.Errorf("have %+v, want %+v", b, want)
+	}
+
+	if have := bot.callbacks[1].URL; !strings.Contains(have, "game=triplesmulti") {
+		t.Errorf("unexpected multi URL %s", have)
+	}
+	u, err = url.Parse(bot.callbacks[1].URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = decode(u.Query().Get("key"), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want.Game = "triplesmulti"
+	if b != want {
+		t.Errorf("have %+v, want %+v", b, want)
+	}
+}
+
+func deliverQueue(bot BotClient, queue *ScoreQueue) {
+	queue.deliverDue(time.Now(), func(b Blob, score int) error {
+		return sendScore(bot, b, score)
+	})
+}
+
+func TestSendScore(t *testing.T) {
+	var (
+		bot      = newFakeBot()
+		key      = genKey()
+		queue, _ = openScoreQueue("")
+		score    = handleScore(queue, key)
+		blob     = Blob{UserID: 7, ChatID: 42, MessageID: 3}
+	)
+	if err := score("garbage", 10); !errors.Is(err, errBadKey) {
+		t.Errorf("have %v, want %v", err, errBadKey)
+	}
+	if err := score(encode(blob, key), 10); err != nil {
+		t.Fatal(err)
+	}
+	deliverQueue(bot, queue)
+
+	sent := bot.Sent()
+	if have, want := len(sent), 1; have != want {
+		t.Fatalf("have %v, want %v", have, want)
+	}
+	sc, ok := sent[0].(tgbotapi.SetGameScoreConfig)
+	if !ok {
+		t.Fatalf("have %T, want SetGameScoreConfig", sent[0])
+	}
+	if sc.UserID != 7 || sc.Score != 10 || sc.ChatID != 42 || sc.MessageID != 3 {
+		t.Errorf("have %+v", sc)
+	}
+}
+
+func TestSendScoreErrors(t *testing.T) {
+	for _, c := range []struct {
+		err                         error
+		pending, delivered, dropped int
+	}{
+		{errors.New("connection reset"), 1, 0, 0},
+		{tgbotapi.Error{Message: "Too Many Requests", ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 5}}, 1, 0, 0},
+		{tgbotapi.Error{Message: "Bad Request: BOT_SCORE_NOT_MODIFIED"}, 0, 1, 0},
+		{tgbotapi.Error{Message: "Bad Request: message not found"}, 0, 0, 1},
+	} {
+		bot := newFakeBot()
+		bot.sendErr = c.err
+		queue, _ := openScoreQueue("")
+		if err := queue.push(Blob{UserID: 7}, 10); err != nil {
+			t.Fatal(err)
+		}
+		deliverQueue(bot, queue)
+		if st := queue.status(); st.Pending != c.pending || st.Delivered != c.delivered || st.Dropped != c.dropped {
+			t.Errorf("%v: have %+v", c.err, st)
+		}
+	}
+}
+
+func TestSendResults(t *testing.T) {
+	var (
+		bot      = newFakeBot()
+		key      = genKey()
+		queue, _ = openScoreQueue("")
+		actions  = make(chan BotAction, 10)
+		results  = handleResults(queue, actions, key)
+	)
+	results(map[string]string{
+		"Ann": encode(Blob{UserID: 7, ChatID: 42, MessageID: 3}, key),
+		"Bob": encode(Blob{UserID: 8, ChatID: 42, MessageID: 3}, key),
+		"Eve": "garbage",
+	}, map[string]int{"Ann": 3, "Bob": 5, "Eve": 1, "Tom": -1})
+	close(actions)
+	for a := range actions {
+		a(bot)
+	}
+	deliverQueue(bot, queue)
+
+	var (
+		scores = map[int]int{}
+		texts  []string
+	)
+	for _, c := range bot.Sent() {
+		switch c := c.(type) {
+		case tgbotapi.SetGameScoreConfig:
+			scores[c.UserID] = c.Score
+		case tgbotapi.MessageConfig:
+			if c.ChatID != 42 {
+				t.Errorf("message to %d", c.ChatID)
+			}
+			texts = append(texts, c.Text)
+		default:
+			t.Errorf("unexpected %T", c)
+		}
+	}
+	if len(scores) != 2 || scores[7] != 3 || scores[8] != 5 {
+		t.Errorf("have scores %v", scores)
+	}
+	want := "Game over!\n1. Bob: 5\n2. Ann: 3\n3. Eve: 1\n4. Tom: -1"
+	if len(texts) != 1 || texts[0] != want {
+		t.Errorf("have %q, want %q", texts, want)
+	}
+}
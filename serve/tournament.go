@@ -0,0 +1,129 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/robx/triples/serve/triples"
+)
+
+const (
+	maxRounds  = 10
+	roundBreak = 10 * time.Second
+)
+
+// tournament is a match of several rounds in one room. Scores are
+// added up over the rounds; ties are broken by the number of rounds
+// won, then by the best single round.
+type tournament struct {
+	types  []triples.Type // the variant of each round
+	played int
+	totals map[string]int
+	wins   map[string]int
+	best   map[string]int
+}
+
+func newTournament(first triples.Type, rounds int, alternate bool) *tournament {
+	if rounds > maxRounds {
+		rounds = maxRounds
+	}
+	t := &tournament{
+		totals: map[string]int{},
+		wins:   map[string]int{},
+		best:   map[string]int{},
+	}
+	typ := first
+	for i := 0; i < rounds; i++ {
+		t.types = append(t.types, typ)
+		if alternate {
+			typ = 1 - typ
+		}
+	}
+	return t
+}
+
+// next is the variant of the coming round.
+func (t *tournament) next() triples.Type {
+	return t.types[t.played]
+}
+
+func (t *tournament) done() bool {
+	return t.played >= len(t.types)
+}
+
+// finishRound adds the scores of a round to the standings.
+func (t *tournament) finishRound(scores map[string]int) {
+	t.played++
+	top, first := 0, true
+	for name, s := range scores {
+		if first || s > top {
+			top, first = s, false
+		}
+		if b, ok := t.best[name]; !ok || s > b {
+			t.best[name] = s
+		}
+		t.totals[name] += s
+	}
+	for name, s := range scores {
+		if s == top {
+			t.wins[name]++
+		}
+	}
+}
+
+func (t *tournament) standings() []Standing {
+	var ss []Standing
+	for name, total := range t.totals {
+		ss = append(ss, Standing{
+			Name:  name,
+			Score: total,
+			Wins:  t.wins[name],
+			Best:  t.best[name],
+		})
+	}
+	sort.Slice(ss, func(i, j int) bool {
+		if !ss[i].tied(ss[j]) {
+			return ss[i].ahead(ss[j])
+		}
+		return ss[i].Name < ss[j].Name
+	})
+	for i := range ss {
+		if i > 0 && ss[i].tied(ss[i-1]) {
+			ss[i].Place = ss[i-1].Place
+		} else {
+			ss[i].Place = i + 1
+		}
+	}
+	return ss
+}
+
+func (t *tournament) update() EventStandings {
+	return EventStandings{
+		Round:     t.played,
+		Rounds:    len(t.types),
+		Standings: t.standings(),
+	}
+}
+
+// Standing is a player's place in a tournament.
+type Standing struct {
+	Place int    `json:"place"`
+	Name  string `json:"name"`
+	Score int    `json:"score"`
+	Wins  int    `json:"wins"`
+	Best  int    `json:"best"`
+}
+
+func (s Standing) tied(o Standing) bool {
+	return s.Score == o.Score && s.Wins == o.Wins && s.Best == o.Best
+}
+
+func (s Standing) ahead(o Standing) bool {
+	if s.Score != o.Score {
+		return s.Score > o.Score
+	}
+	if s.Wins != o.Wins {
+		return s.Wins > o.Wins
+	}
+	return s.Best > o.Best
+}
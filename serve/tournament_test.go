@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/robx/triples/serve/triples"
+)
+
+func TestTournamentRounds(t *testing.T) {
+	tm := newTournament(triples.Quadruples, 3, true)
+	want := []triples.Type{triples.Quadruples, triples.Triples, triples.Quadruples}
+	for i, typ := range want {
+		if tm.done() {
+			t.Fatalf("done after %d rounds", i)
+		}
+		if have := tm.next(); have != typ {
+			t.Errorf("round %d: have %v, want %v", i+1, have, typ)
+		}
+		tm.finishRound(map[string]int{"Ann": 1})
+	}
+	if !tm.done() {
+		t.Error("not done")
+	}
+}
+
+func TestTournamentStandings(t *testing.T) {
+	tm := newTournament(triples.Triples, 3, false)
+	tm.finishRound(map[string]int{"Ann": 10, "Bob": 5, "Eve": 7, "Tom": 7})
+	tm.finishRound(map[string]int{"Ann": 2, "Bob": 7, "Eve": 5, "Tom": 5})
+	tm.finishRound(map[string]int{"Ann": 3, "Bob": 3, "Eve": 3, "Tom": 3})
+	// Ann and Bob both have 15 points and won two rounds, but
+	// Ann had the better round; Eve and Tom can't be separated
+	want := []Standing{
+		{Place: 1, Name: "Ann", Score: 15, Wins: 2, Best: 10},
+		{Place: 2, Name: "Bob", Score: 15, Wins: 2, Best: 7},
+		{Place: 3, Name: "Eve", Score: 15, Wins: 1, Best: 7},
+		{Place: 3, Name: "Tom", Score: 15, Wins: 1, Best: 7},
+	}
+	if have := tm.standings(); !reflect.DeepEqual(have, want) {
+		t.Errorf("have %+v, want %+v", have, want)
+	}
+	if have, want := tm.update().Round, 3; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+}
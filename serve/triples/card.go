@@ -0,0 +1,97 @@
+// Package triples implements the cards and rules of triples and
+// quadruples: the card encoding, the match predicates, and a game
+// engine that deals cards onto a board and scores claims.
+//
+// A card is an integer 0 <= c < 81, whose base 3 digits are, from
+// least significant, its color, count, shape and filling. This is
+// the encoding the clients use on the wire.
+package triples
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// NumCards is the number of cards in a full deck.
+const NumCards = 81
+
+// Card is a single card.
+type Card int
+
+var (
+	colorNames = [3]string{"red", "green", "purple"}
+	shapeNames = [3]string{"diamond", "oval", "squiggle"}
+	fillNames  = [3]string{"solid", "striped", "open"}
+)
+
+// Color is the card's color, 0 to 2.
+func (c Card) Color() int { return int(c) % 3 }
+
+// Count is the number of symbols on the card, less one.
+func (c Card) Count() int { return int(c) / 3 % 3 }
+
+// Shape is the card's shape, 0 to 2.
+func (c Card) Shape() int { return int(c) / 9 % 3 }
+
+// Fill is the card's filling, 0 to 2.
+func (c Card) Fill() int { return int(c) / 27 % 3 }
+
+// Valid reports whether c is a card of the deck.
+func (c Card) Valid() bool {
+	return c >= 0 && c < NumCards
+}
+
+// String describes the card, like "2 green striped ovals".
+func (c Card) String() string {
+	if !c.Valid() {
+		return "Card(" + strconv.Itoa(int(c)) + ")"
+	}
+	s := fmt.Sprintf("%d %s %s %s", c.Count()+1,
+		colorNames[c.Color()], fillNames[c.Fill()], shapeNames[c.Shape()])
+	if c.Count() > 0 {
+		s += "s"
+	}
+	return s
+}
+
+// ParseCard reads a card as written by String.
+func ParseCard(s string) (Card, error) {
+	fs := strings.Fields(s)
+	if len(fs) != 4 {
+		return 0, fmt.Errorf("bad card %q", s)
+	}
+	n, err := strconv.Atoi(fs[0])
+	if err != nil || n < 1 || n > 3 {
+		return 0, fmt.Errorf("bad card %q: bad count", s)
+	}
+	shape := fs[3]
+	if n > 1 {
+		shape = strings.TrimSuffix(shape, "s")
+	}
+	digits := [3]int{}
+	for i, p := range []struct {
+		word  string
+		names [3]string
+	}{
+		{fs[1], colorNames},
+		{shape, shapeNames},
+		{fs[2], fillNames},
+	} {
+		k := index(p.names, strings.ToLower(p.word))
+		if k < 0 {
+			return 0, fmt.Errorf("bad card %q: unknown %q", s, p.word)
+		}
+		digits[i] = k
+	}
+	return Card(digits[0] + 3*(n-1) + 9*digits[1] + 27*digits[2]), nil
+}
+
+func index(names [3]string, s string) int {
+	for i, n := range names {
+		if n == s {
+			return i
+		}
+	}
+	return -1
+}
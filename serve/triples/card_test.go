@@ -0,0 +1,35 @@
+package triples
+
+import (
+	"testing"
+)
+
+func TestCardString(t *testing.T) {
+	for _, tc := range []struct {
+		card Card
+		want string
+	}{
+		{0, "1 red solid diamond"},
+		{4, "2 green solid diamonds"},
+		{80, "3 purple open squiggles"},
+		{81, "Card(81)"},
+	} {
+		if have := tc.card.String(); have != tc.want {
+			t.Errorf("%d: have %q, want %q", tc.card, have, tc.want)
+		}
+	}
+	for c := Card(0); c < NumCards; c++ {
+		p, err := ParseCard(c.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p != c {
+			t.Errorf("%q: have %d, want %d", c, p, c)
+		}
+	}
+	for _, s := range []string{"", "4 red solid diamonds", "1 blue solid diamond", "2 red solid"} {
+		if _, err := ParseCard(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package triples
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// Position is a place on the board. Boards are three rows high
+// and grow to the right, with X the column and Y the row.
+type Position struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type PlacedCard struct {
+	Position Position `json:"position"`
+	Card     Card     `json:"card"`
+}
+
+type Move struct {
+	From Position `json:"from"`
+	To   Position `json:"to"`
+}
+
+// Result is the outcome of a claim.
+type Result string
+
+const (
+	Correct Result = "correct"
+	Wrong   Result = "wrong"
+	Late    Result = "late" // the cards are gone, or the claim is moot
+)
+
+// Game is a game in progress: the deck, the cards on the board,
+// and the players' scores.
+type Game struct {
+	Type           Type
+	DefaultColumns int
+	Deck           []Card
+	Cards          map[Position]Card
+	Scores         map[string]int
+	ClaimedNoMatch bool
+}
+
+// NewGame starts a game of the given type with a shuffled deck
+// and an empty board.
+func NewGame(t Type) *Game {
+	deck := make([]Card, NumCards)
+	for i, c := range rand.Perm(NumCards) {
+		deck[i] = Card(c)
+	}
+	return &Game{
+		Type:           t,
+		DefaultColumns: t.DefaultColumns(),
+		Deck:           deck,
+		Cards:          map[Position]Card{},
+		Scores:         map[string]int{},
+	}
+}
+
+func (g *Game) DeckSize() int {
+	return len(g.Deck)
+}
+
+// Add enters a player, keeping their score if they were there before.
+func (g *Game) Add(player string) {
+	g.Scores[player] = g.Scores[player]
+}
+
+func (g *Game) FindCard(c Card) (Position, bool) {
+	for p, cc := range g.Cards {
+		if cc == c {
+			return p, true
+		}
+	}
+	return Position{}, false
+}
+
+func (g *Game) ListCards() []Card {
+	var cs []Card
+	for _, c := range g.Cards {
+		cs = append(cs, c)
+	}
+	return cs
+}
+
+// EachMatch calls f with every match on the board.
+func (g *Game) EachMatch(f func([]Card)) {
+	EachMatch(g.Type, g.ListCards(), f)
+}
+
+func (g *Game) CountMatches() int {
+	count := 0
+	g.EachMatch(func([]Card) { count++ })
+	return count
+}
+
+func (g *Game) ListMatches() [][]Card {
+	return Matches(g.Type, g.ListCards())
+}
+
+// GameOver reports whether the deck is empty and there are no
+// matches left on the board.
+func (g *Game) GameOver() bool {
+	if len(g.Deck) > 0 {
+		return false
+	}
+	return g.CountMatches() == 0
+}
+
+func (g *Game) IsMatch(cards []Card) bool {
+	return IsMatch(g.Type, cards)
+}
+
+// ClaimMatch scores a player's claim that cards are a match,
+// returning the player's new score and, if the claim was correct,
+// the positions the cards were taken from. A card given twice
+// makes the claim wrong.
+func (g *Game) ClaimMatch(name string, cards []Card) (Result, int, []Position) {
+	var ps []Position
+	if g.GameOver() {
+		return Late, g.Scores[name], nil
+	}
+	seen := map[Card]bool{}
+	distinct := true
+	for _, c := range cards {
+		if p, ok := g.FindCard(c); !ok {
+			return Late, g.Scores[name], nil
+		} else {
+			ps = append(ps, p)
+		}
+		distinct = distinct && !seen[c]
+		seen[c] = true
+	}
+	if distinct && g.IsMatch(cards) {
+		for _, p := range ps {
+			delete(g.Cards, p)
+		}
+		g.Scores[name] += 1
+		return Correct, g.Scores[name], ps
+	}
+	g.Scores[name] -= 1
+	return Wrong, g.Scores[name], nil
+}
+
+// ClaimNoMatch scores a player's claim that there is no match among
+// cards, which must be the whole board. If the claim was correct,
+// another column is dealt, and the new cards are returned.
+func (g *Game) ClaimNoMatch(name string, cards []Card) (Result, int, []PlacedCard) {
+	if g.GameOver() || len(cards) < 3*g.DefaultColumns || g.ClaimedNoMatch {
+		return Late, g.Scores[name], nil
+	}
+	cs := g.ListCards()
+	equal := func(as, bs []Card) bool {
+		if len(as) != len(bs) {
+			return false
+		}
+		sort.Slice(as, func(i, j int) bool { return as[i] < as[j] })
+		sort.Slice(bs, func(i, j int) bool { return bs[i] < bs[j] })
+		for i := 0; i < len(as); i++ {
+			if as[i] != bs[i] {
+				return false
+			}
+		}
+		return true
+	}
+	if !equal(cs, cards) {
+		return Late, g.Scores[name], nil
+	}
+	if g.CountMatches() == 0 {
+		g.Scores[name] += 1
+		return Correct, g.Scores[name], g.DealMore()
+	}
+	g.ClaimedNoMatch = true
+	g.Scores[name] -= 1
+	return Wrong, g.Scores[name], nil
+}
+
+// DealMore deals an extra column.
+func (g *Game) DealMore() []PlacedCard {
+	var cs []PlacedCard
+	x := g.Columns()
+	for y := 0; y < 3; y++ {
+		p := Position{X: x, Y: y}
+		if len(g.Deck) > 0 {
+			c := g.Deck[0]
+			g.Deck = g.Deck[1:]
+			g.Cards[p] = c
+			cs = append(cs, PlacedCard{p, c})
+		}
+	}
+	g.ClaimedNoMatch = false
+	return cs
+}
+
+func (g *Game) Columns() int {
+	m := -1
+	for p := range g.Cards {
+		if p.X+1 > m {
+			m = p.X + 1
+		}
+	}
+	if dc := g.DefaultColumns; m < dc {
+		return dc
+	}
+	return m
+}
+
+func (g *Game) empty(p Position) bool {
+	_, ok := g.Cards[p]
+	return !ok
+}
+
+// Compact moves cards from extra columns into the gaps
+// of the default columns.
+func (g *Game) Compact() []Move {
+	cols := g.Columns()
+	up := func(p Position) Position {
+		if p.Y == 2 {
+			return Position{X: p.X + 1, Y: 0}
+		} else {
+			return Position{X: p.X, Y: p.Y + 1}
+		}
+	}
+	down := func(p Position) Position {
+		if p.Y == 0 {
+			return Position{X: p.X - 1, Y: 2}
+		} else {
+			return Position{X: p.X, Y: p.Y - 1}
+		}
+	}
+	l := Position{X: 0, Y: 0}
+	h := Position{X: cols - 1, Y: 2}
+	var moves []Move
+	for {
+		for ; !g.empty(l) && l.X < cols; l = up(l) {
+		}
+		for ; g.empty(h) && h.X > l.X && h.X >= g.DefaultColumns; h = down(h) {
+		}
+		if g.empty(l) && !g.empty(h) && h.X > l.X && h.X >= g.DefaultColumns {
+			g.Cards[l] = g.Cards[h]
+			delete(g.Cards, h)
+			moves = append(moves, Move{
+				From: h,
+				To:   l,
+			})
+		} else {
+			break
+		}
+	}
+	return moves
+}
+
+// Deal fills the gaps in the default columns from the deck.
+func (g *Game) Deal() []PlacedCard {
+	var cs []PlacedCard
+	for x := 0; x < g.DefaultColumns; x++ {
+		for y := 0; y < 3; y++ {
+			p := Position{X: x, Y: y}
+			if _, ok := g.Cards[p]; !ok {
+				if len(g.Deck) > 0 {
+					c := g.Deck[0]
+					g.Deck = g.Deck[1:]
+					g.Cards[p] = c
+					cs = append(cs, PlacedCard{p, c})
+				}
+			}
+		}
+	}
+	if len(cs) > 0 {
+		g.ClaimedNoMatch = false
+	}
+	return cs
+}
@@ -0,0 +1,109 @@
+package triples
+
+// Type is the variant of the game.
+type Type int
+
+const (
+	Triples    Type = 0
+	Quadruples Type = 1
+)
+
+var (
+	matchSizes     = [2]int{3, 4}
+	defaultColumns = [2]int{4, 3}
+)
+
+func (t Type) String() string {
+	if t == Quadruples {
+		return "quadruples"
+	}
+	return "triples"
+}
+
+// MatchSize is the number of cards in a match.
+func (t Type) MatchSize() int {
+	return matchSizes[t]
+}
+
+// DefaultColumns is the number of columns of a full board.
+func (t Type) DefaultColumns() int {
+	return defaultColumns[t]
+}
+
+// IsTriple reports whether, for each property by itself, the three
+// cards are all the same or all different.
+func IsTriple(x, y, z Card) bool {
+	for i := 0; i < 4; i++ {
+		if (x+y+z)%3 != 0 {
+			return false
+		}
+		x /= 3
+		y /= 3
+		z /= 3
+	}
+	return true
+}
+
+// Third is the card that completes a and b to a triple.
+func Third(a, b Card) Card {
+	c := Card(0)
+	f := Card(1)
+	for i := 0; i < 4; i++ {
+		c += f * ((6 - a%3 - b%3) % 3)
+		a /= 3
+		b /= 3
+		f *= 3
+	}
+	return c
+}
+
+// IsQuadruple reports whether the four cards can be split in two
+// pairs that are completed to a triple by the same fifth card.
+func IsQuadruple(x, y, z, w Card) bool {
+	return Third(x, y) == Third(z, w) || Third(x, z) == Third(y, w) || Third(x, w) == Third(y, z)
+}
+
+// IsMatch reports whether cards form a match of the given type.
+func IsMatch(t Type, cards []Card) bool {
+	switch t {
+	case Quadruples:
+		return len(cards) == 4 && IsQuadruple(cards[0], cards[1], cards[2], cards[3])
+	default:
+		return len(cards) == 3 && IsTriple(cards[0], cards[1], cards[2])
+	}
+}
+
+// EachMatch calls f with every match of the given type among cards.
+func EachMatch(t Type, cards []Card, f func([]Card)) {
+	switch t {
+	case Quadruples:
+		for i := 0; i < len(cards); i++ {
+			for j := i + 1; j < len(cards); j++ {
+				for k := j + 1; k < len(cards); k++ {
+					for l := k + 1; l < len(cards); l++ {
+						if IsQuadruple(cards[i], cards[j], cards[k], cards[l]) {
+							f([]Card{cards[i], cards[j], cards[k], cards[l]})
+						}
+					}
+				}
+			}
+		}
+	default:
+		for i := 0; i < len(cards); i++ {
+			for j := i + 1; j < len(cards); j++ {
+				for k := j + 1; k < len(cards); k++ {
+					if IsTriple(cards[i], cards[j], cards[k]) {
+						f([]Card{cards[i], cards[j], cards[k]})
+					}
+				}
+			}
+		}
+	}
+}
+
+// Matches lists every match of the given type among cards.
+func Matches(t Type, cards []Card) [][]Card {
+	var ms [][]Card
+	EachMatch(t, cards, func(m []Card) { ms = append(ms, m) })
+	return ms
+}
@@ -0,0 +1,71 @@
+package triples
+
+import (
+	"testing"
+)
+
+func TestThird(t *testing.T) {
+	for a := Card(0); a < NumCards; a++ {
+		for b := Card(0); b < NumCards; b++ {
+			c := Third(a, b)
+			if !c.Valid() || !IsTriple(a, b, c) {
+				t.Fatalf("%d, %d: have %d", a, b, c)
+			}
+		}
+	}
+}
+
+func TestIsQuadruple(t *testing.T) {
+	for _, tc := range []struct {
+		cards [4]Card
+		want  bool
+	}{
+		{[4]Card{0, 1, 3, 5}, true},
+		{[4]Card{0, 1, 2, 3}, false},
+		{[4]Card{10, 20, 30, 40}, true},
+		{[4]Card{80, 79, 1, 2}, true},
+		{[4]Card{4, 17, 33, 62}, false},
+	} {
+		a, b, c, d := tc.cards[0], tc.cards[1], tc.cards[2], tc.cards[3]
+		if have := IsQuadruple(a, b, c, d); have != tc.want {
+			t.Errorf("%v: have %v, want %v", tc.cards, have, tc.want)
+		}
+	}
+}
+
+func TestIsQuadrupleByFifthCard(t *testing.T) {
+	// completes tells whether some card makes triples with both pairs
+	completes := func(a, b, c, d Card) bool {
+		for e := Card(0); e < NumCards; e++ {
+			if IsTriple(a, b, e) && IsTriple(c, d, e) {
+				return true
+			}
+		}
+		return false
+	}
+	for a := Card(0); a < 27; a++ {
+		for b := a + 1; b < 27; b++ {
+			for c := b + 1; c < 27; c++ {
+				for d := c + 1; d < 27; d++ {
+					want := completes(a, b, c, d) || completes(a, c, b, d) || completes(a, d, b, c)
+					if have := IsQuadruple(a, b, c, d); have != want {
+						t.Fatalf("%d, %d, %d, %d: have %v, want %v", a, b, c, d, have, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	var deck []Card
+	for c := Card(0); c < NumCards; c++ {
+		deck = append(deck, c)
+	}
+	if have, want := len(Matches(Triples, deck)), 1080; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	if !IsMatch(Triples, []Card{0, 1, 2}) || IsMatch(Triples, []Card{0, 1}) {
+		t.Error("IsMatch gets the size wrong")
+	}
+}
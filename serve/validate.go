@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/robx/triples/serve/triples"
+)
+
+// validate checks a command from a client against the game in
+// progress, if any, before the room acts on it. Claims for cards
+// that have left the board aren't invalid, just late.
+func validate(c Command, g *triples.Game) error {
+	switch cmd := c.(type) {
+	case CmdClaim:
+		if cmd.Type != ClaimMatch && cmd.Type != ClaimNoMatch {
+			return fmt.Errorf("unknown claim type %q", cmd.Type)
+		}
+		if len(cmd.Cards) > triples.NumCards {
+			return fmt.Errorf("too many cards")
+		}
+		seen := map[triples.Card]bool{}
+		for _, card := range cmd.Cards {
+			if !card.Valid() {
+				return fmt.Errorf("no such card: %d", card)
+			}
+			if seen[card] {
+				return fmt.Errorf("card %d claimed twice", card)
+			}
+			seen[card] = true
+		}
+		if g == nil {
+			return nil
+		}
+		if n := g.Type.MatchSize(); cmd.Type == ClaimMatch && len(cmd.Cards) != n {
+			return fmt.Errorf("a match has %d cards, not %d", n, len(cmd.Cards))
+		}
+		for _, card := range g.Deck {
+			if seen[card] {
+				return fmt.Errorf("card %d is not on the board", card)
+			}
+		}
+	case CmdStart:
+		if cmd.Rounds < 0 {
+			return fmt.Errorf("negative rounds")
+		}
+	case CmdAddBot:
+		if _, ok := difficulties[cmd.Level]; cmd.Level != "" && !ok {
+			return fmt.Errorf("unknown bot difficulty %q", cmd.Level)
+		}
+	case CmdReact:
+		if !reactions[cmd.Reaction] {
+			return fmt.Errorf("unknown reaction %q", cmd.Reaction)
+		}
+	}
+	return nil
+}
+
+// commandTag is the name of a command in the protocol.
+func commandTag(c Command) string {
+	for tag, proto := range commandTypes {
+		if reflect.TypeOf(proto) == reflect.TypeOf(c) {
+			return tag
+		}
+	}
+	return ""
+}
+
+// EventInvalid tells a client that a command of theirs was refused.
+type EventInvalid struct {
+	Command string `json:"command"`
+	Error   string `json:"error"`
+}
+
+func (u EventInvalid) isUpdate()   {}
+func (u EventInvalid) tag() string { return "eventInvalid" }
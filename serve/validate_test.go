@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/robx/triples/serve/triples"
+)
+
+func TestValidate(t *testing.T) {
+	g := &triples.Game{
+		Type:  triples.Triples,
+		Deck:  []triples.Card{9, 10},
+		Cards: map[triples.Position]triples.Card{{X: 0}: 0, {X: 1}: 1, {X: 2}: 2, {Y: 1}: 3},
+	}
+	match := func(cards ...triples.Card) CmdClaim { return CmdClaim{Type: ClaimMatch, Cards: cards} }
+	for _, c := range []struct {
+		cmd  Command
+		want string
+	}{
+		{match(0, 1, 2), ""},
+		{match(0, 1, 80), ""}, // late
+		{match(0, 0, 0), "twice"},
+		{match(0, 1, 81), "no such card"},
+		{match(0, 1, -1), "no such card"},
+		{match(0, 1), "3 cards"},
+		{match(0, 1, 2, 3), "3 cards"},
+		{match(0, 1, 9), "not on the board"},
+		{CmdClaim{Type: "maybe", Cards: []triples.Card{0, 1, 2}}, "claim type"},
+		{CmdClaim{Type: ClaimNoMatch, Cards: []triples.Card{0, 1, 2, 3}}, ""},
+		{CmdClaim{Type: ClaimNoMatch, Cards: make([]triples.Card, 100)}, "too many"},
+		{CmdStart{Rounds: -1}, "rounds"},
+		{CmdAddBot{Level: "godlike"}, "difficulty"},
+		{CmdAddBot{}, ""},
+		{CmdReact{Reaction: "💩"}, "reaction"},
+		{CmdChat{Text: "hi"}, ""},
+	} {
+		err := validate(c.cmd, g)
+		if c.want == "" && err != nil || c.want != "" && (err == nil || !strings.Contains(err.Error(), c.want)) {
+			t.Errorf("%+v: have %v, want %q", c.cmd, err, c.want)
+		}
+	}
+
+	// outside of games, only what doesn't need the board
+	if err := validate(match(0, 1), nil); err != nil {
+		t.Errorf("no game: have %v", err)
+	}
+	if err := validate(match(0, 0, 0), nil); err == nil {
+		t.Errorf("no game: duplicates passed")
+	}
+}
+
+func TestInvalidCommand(t *testing.T) {
+	r := newRoom("triplesmulti", "invalid", nil, nil)
+	defer r.close()
+	alice, send := joinRoom(r, "alice", "")
+	send(CmdReact{Reaction: "nope"})
+	waitFor(t, alice, func(u Update) bool {
+		e, ok := u.(EventInvalid)
+		return ok && e.Command == "react" && strings.Contains(e.Error, "nope")
+	})
+}